@@ -65,7 +65,12 @@ func Delete(ctx context.Context, url string, params map[string]any, opts ...ReqP
 }
 
 func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
-	requestId := uuid.New().ID()
+	// 沿用上游的请求ID，没有时生成一个，日志和下游请求使用同一个ID
+	requestId := zLog.RequestIdFromContext(ctx)
+	if requestId == "" {
+		requestId = uuid.NewString()
+		ctx = zLog.ContextWithRequestId(ctx, requestId)
+	}
 	startRequestTime := time.Now().UnixMilli()
 
 	var err error
@@ -127,6 +132,9 @@ func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
 			}
 		}
 	}
+	if RequestIdHeader != "" && request.Header.Get(RequestIdHeader) == "" {
+		request.Header.Set(RequestIdHeader, requestId)
+	}
 	// 设置超时时间
 	ctx, cancel := context.WithCancel(ctx)
 	time.AfterFunc(time.Duration(req.timeout)*time.Millisecond, func() {
@@ -142,7 +150,6 @@ func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
 		zLog.TraceError(
 			ctx,
 			"request failed",
-			zap.Uint32("requestId", requestId),
 			zap.Int64("timeDuration", time.Now().UnixMilli()-startRequestTime),
 			zap.Error(err),
 		)
@@ -154,7 +161,6 @@ func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
 		zLog.TraceInfo(
 			ctx,
 			"request failed",
-			zap.Uint32("requestId", requestId),
 			zap.String("path", req.Path),
 			zap.Int("code", resp.StatusCode),
 			zap.Int64("timeDuration", time.Now().UnixMilli()-startRequestTime),
@@ -168,7 +174,6 @@ func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
 		zLog.TraceError(
 			ctx,
 			"read body failed",
-			zap.Uint32("requestId", requestId),
 			zap.String("path", req.Path),
 			zap.Int("code", resp.StatusCode),
 			zap.Error(err),
@@ -179,7 +184,6 @@ func do(ctx context.Context, req *requestParamsDto) *ResponseDto {
 	zLog.TraceInfo(
 		ctx,
 		"request done",
-		zap.Uint32("requestId", requestId),
 		zap.String("path", req.Path),
 		zap.Int64("timeDuration(ms)", time.Now().UnixMilli()-startRequestTime),
 	)
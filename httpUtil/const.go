@@ -11,3 +11,6 @@ const (
 
 	defaultTimeout = 3000
 )
+
+// RequestIdHeader 转发请求ID使用的header，由middleware.RequestIdWithConf设置为RequestIdConf.Header，为空时不转发
+var RequestIdHeader = "X-Request-Id"
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthzPolicy 基于JWT声明的鉴权策略，返回false时请求被拒绝
+type AuthzPolicy func(c *gin.Context, claims *JWTClaims) bool
+
+// 兼容ParseJWTToken/ValidateJWTToken只把声明逐个写入gin.Context的情况
+var authzClaimKeys = []string{"sub", "scope", "scp", "roles", "role"}
+
+// Scopes 读取scope(空格分隔)或scp(数组或字符串)声明
+func (c *JWTClaims) Scopes() []string {
+	if s, ok := c.Raw["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	return claimStrings(c.Raw["scp"])
+}
+
+// Roles 读取roles或role声明
+func (c *JWTClaims) Roles() []string {
+	if roles := claimStrings(c.Raw["roles"]); len(roles) > 0 {
+		return roles
+	}
+	return claimStrings(c.Raw["role"])
+}
+
+// RequireScopes 要求token包含全部scope
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return requirePolicy("insufficient_scope", HasScopes(scopes...))
+}
+
+// RequireRoles 要求token包含任意一个role
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return requirePolicy("insufficient_role", HasAnyRole(roles...))
+}
+
+// RequirePolicy 自定义鉴权策略，可以按路由组分别配置，如
+//
+//	admin := r.Group("/admin", VerifyJWTToken(conf), RequirePolicy(AllPolicies(HasAnyRole("admin"), HasScopes("write"))))
+func RequirePolicy(policy AuthzPolicy) gin.HandlerFunc {
+	return requirePolicy("forbidden", policy)
+}
+
+func HasScopes(scopes ...string) AuthzPolicy {
+	return func(_ *gin.Context, claims *JWTClaims) bool {
+		return containsAll(claims.Scopes(), scopes)
+	}
+}
+
+func HasAnyRole(roles ...string) AuthzPolicy {
+	return func(_ *gin.Context, claims *JWTClaims) bool {
+		return intersects(claims.Roles(), roles)
+	}
+}
+
+// AllPolicies 所有策略都通过才放行
+func AllPolicies(policies ...AuthzPolicy) AuthzPolicy {
+	return func(c *gin.Context, claims *JWTClaims) bool {
+		for _, p := range policies {
+			if !p(c, claims) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyPolicy 任意一个策略通过即放行
+func AnyPolicy(policies ...AuthzPolicy) AuthzPolicy {
+	return func(c *gin.Context, claims *JWTClaims) bool {
+		for _, p := range policies {
+			if p(c, claims) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func requirePolicy(reason string, policy AuthzPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authzClaims(c)
+		if !ok {
+			// 前面没有JWT中间件或token无效
+			abortJWTUnauthorized(c, ErrJWTMissing)
+			return
+		}
+		if !policy(c, claims) {
+			c.AbortWithStatusJSON(
+				http.StatusForbidden,
+				gin.H{
+					"code":    403,
+					"message": "Forbidden",
+					"reason":  reason,
+					"success": false,
+				},
+			)
+			return
+		}
+		c.Next()
+	}
+}
+
+func authzClaims(c *gin.Context) (*JWTClaims, bool) {
+	if claims, ok := ClaimsFromContext(c); ok {
+		return claims, true
+	}
+	if _, ok := c.Get(JWTTokenKey); !ok {
+		return nil, false
+	}
+	raw := make(map[string]any, len(authzClaimKeys))
+	for _, k := range authzClaimKeys {
+		if v, ok := c.Get(k); ok {
+			raw[k] = v
+		}
+	}
+	return newJWTClaims(raw), true
+}
+
+func claimStrings(v any) []string {
+	switch s := v.(type) {
+	case string:
+		return strings.Fields(s)
+	case []string:
+		return s
+	case []any:
+		out := make([]string, 0, len(s))
+		for _, item := range s {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsAll(have, want []string) bool {
+	set := make(map[string]struct{}, len(have))
+	for _, h := range have {
+		set[h] = struct{}{}
+	}
+	for _, w := range want {
+		if _, ok := set[w]; !ok {
+			return false
+		}
+	}
+	return true
+}
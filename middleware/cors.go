@@ -1,30 +1,185 @@
 package middleware
 
 import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RollNA/harbour/zLog"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
+type CorsConfig struct {
+	// 允许的origin，支持精确匹配、"*"和通配子域名如"https://*.example.com"
+	AllowOrigins []string `mapstructure:"allowOrigins"`
+	// 允许的origin正则，按整个origin匹配(自动加上^和$)，如"https://[a-z]+\.example\.com"
+	AllowOriginRegexps []string `mapstructure:"allowOriginRegexps"`
+	// 自定义origin校验，与上面的规则任意一个匹配即允许
+	AllowOriginFunc func(origin string) bool `mapstructure:"-"`
+
+	AllowMethods []string `mapstructure:"allowMethods"`
+	// 为空时回显请求中的Access-Control-Request-Headers
+	AllowHeaders  []string `mapstructure:"allowHeaders"`
+	ExposeHeaders []string `mapstructure:"exposeHeaders"`
+	// 开启后回显origin而不是返回"*"，需要明确的origin规则，与AllowOrigins包含"*"同时配置时不生效
+	AllowCredentials bool `mapstructure:"allowCredentials"`
+	// 预检请求的缓存时间
+	MaxAge time.Duration `mapstructure:"maxAge"`
+}
+
+// DefaultCorsConfig 允许所有origin，与之前的Cors()行为一致
+func DefaultCorsConfig() CorsConfig {
+	return CorsConfig{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"authorization", "jwt", "origin", "content-type", "accept"},
+		MaxAge:       time.Hour,
+	}
+}
+
 func Cors() gin.HandlerFunc {
+	return CorsWithConfig(DefaultCorsConfig())
+}
+
+func CorsWithConfig(conf CorsConfig) gin.HandlerFunc {
+	p := newCorsPolicy(conf)
 	return func(c *gin.Context) {
-		if c.Request.Method != "OPTIONS" {
-			// 接收客户端发送的origin
-			c.Header("Access-Control-Allow-Origin", "*")
-			// 服务器支持的所有跨域请求的方法
-			c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			// 允许跨域设置可以返回其他子段，可以自定义字段
-			c.Header("Access-Control-Allow-Headers", "authorization, jwt, origin, content-type, accept")
-			c.Header("Allow", "HEAD,GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			c.Header("Content-Type", "application/json")
+		header := c.Writer.Header()
+		if !p.allowAll {
+			// 响应随origin变化，非跨域请求也要设置，避免缓存的响应被用于跨域请求
+			header.Add("Vary", "Origin")
+		}
+		origin := c.Request.Header.Get("Origin")
+		if origin == "" {
+			// 非跨域请求
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions &&
+			c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		if !p.allowOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
 			c.Next()
+			return
+		}
+
+		if p.allowAll && !p.conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
 		} else {
-			c.Header("Access-Control-Allow-Origin", "*")
-			c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			c.Header("Access-Control-Allow-Headers", "authorization, jwt, origin, content-type, accept")
-			c.Header("Allow", "HEAD,GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			c.Header("Content-Type", "application/json")
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if p.exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", p.allowMethods)
+		header.Set("Allow", p.allowMethods)
+		if p.allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", p.allowHeaders)
+		} else if reqHeaders := c.Request.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if p.maxAge != "" {
 			// 设置缓存时间
-			c.Header("Access-Control-Max-Age", "3600")
-			c.AbortWithStatus(200)
+			header.Set("Access-Control-Max-Age", p.maxAge)
+		}
+		c.AbortWithStatus(http.StatusOK)
+	}
+}
+
+type corsPolicy struct {
+	conf          CorsConfig
+	allowAll      bool
+	origins       map[string]struct{}
+	wildcards     [][2]string // 前缀和后缀，如"https://"和".example.com"
+	regexps       []*regexp.Regexp
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+func newCorsPolicy(conf CorsConfig) *corsPolicy {
+	p := &corsPolicy{
+		conf:          conf,
+		origins:       make(map[string]struct{}),
+		allowMethods:  strings.Join(conf.AllowMethods, ","),
+		allowHeaders:  strings.Join(conf.AllowHeaders, ","),
+		exposeHeaders: strings.Join(conf.ExposeHeaders, ","),
+	}
+	if p.allowMethods == "" {
+		p.allowMethods = strings.Join(DefaultCorsConfig().AllowMethods, ",")
+	}
+	if conf.MaxAge > 0 {
+		p.maxAge = strconv.FormatInt(int64(conf.MaxAge/time.Second), 10)
+	}
+	for _, o := range conf.AllowOrigins {
+		o = strings.ToLower(o)
+		switch {
+		case o == "*":
+			p.allowAll = true
+		case strings.Contains(o, "*"):
+			i := strings.Index(o, "*")
+			p.wildcards = append(p.wildcards, [2]string{o[:i], o[i+1:]})
+		default:
+			p.origins[o] = struct{}{}
+		}
+	}
+	for _, expr := range conf.AllowOriginRegexps {
+		// 不加锚点时"https://a.example.com.evil.net"也能匹配
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			zLog.Error("invalid cors origin regexp", zap.String("regexp", expr), zap.Error(err))
+			continue
 		}
+		p.regexps = append(p.regexps, re)
+	}
+	if p.allowAll && conf.AllowCredentials {
+		// 任意站点都可以带cookie跨域读取响应
+		zLog.Error("cors allowOrigins \"*\" can not be used with allowCredentials, credentials disabled")
+		p.conf.AllowCredentials = false
+	}
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := p.origins[lower]; ok {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	if p.conf.AllowOriginFunc != nil {
+		return p.conf.AllowOriginFunc(origin)
 	}
+	return false
 }
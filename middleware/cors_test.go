@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func corsRequest(r *gin.Engine, origin string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCorsOriginMatching(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CorsWithConfig(CorsConfig{
+		AllowOrigins:       []string{"https://app.example.org", "https://*.example.net"},
+		AllowOriginRegexps: []string{`https://[a-z]+\.example\.com`},
+		AllowCredentials:   true,
+	}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"exact", "https://app.example.org", true},
+		{"exact case insensitive", "https://APP.example.org", true},
+		{"exact other scheme", "http://app.example.org", false},
+		{"wildcard subdomain", "https://a.example.net", true},
+		{"wildcard apex", "https://.example.net", false},
+		{"wildcard suffix bypass", "https://a.example.net.evil.io", false},
+		{"regexp", "https://a.example.com", true},
+		{"regexp suffix bypass", "https://a.example.com.evil.net", false},
+		{"regexp prefix bypass", "https://evil.io/https://a.example.com", false},
+		{"unknown", "https://evil.io", false},
+	}
+	for _, tc := range cases {
+		w := corsRequest(r, tc.origin)
+		assert.Equal(t, http.StatusOK, w.Code, tc.name)
+		if tc.allowed {
+			assert.Equal(t, tc.origin, w.Header().Get("Access-Control-Allow-Origin"), tc.name)
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), tc.name)
+		} else {
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), tc.name)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"), tc.name)
+		}
+		assert.Equal(t, "Origin", w.Header().Get("Vary"), tc.name)
+	}
+
+	// 非跨域请求也要带Vary，避免缓存被跨域请求复用
+	w := corsRequest(r, "")
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsAllowAllWithCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CorsWithConfig(CorsConfig{AllowOrigins: []string{"*"}, AllowCredentials: true}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := corsRequest(r, "https://evil.io")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Empty(t, w.Header().Get("Vary"))
+}
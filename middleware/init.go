@@ -1,70 +1,159 @@
 package middleware
 
 import (
-	"net/http"
-
+	"github.com/RollNA/harbour/tracer"
 	"github.com/RollNA/harbour/zLog"
 	"github.com/gin-contrib/pprof"
 	"go.uber.org/zap"
 
 	"github.com/gin-gonic/gin"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type MiddlewareName string
+
+const (
+	MiddlewareCors       MiddlewareName = "cors"
+	MiddlewareRequestId  MiddlewareName = "requestId"
+	MiddlewarePrometheus MiddlewareName = "prometheus"
+	MiddlewareTrace      MiddlewareName = "trace"
+	MiddlewareLogger     MiddlewareName = "logger"
+	MiddlewareRecovery   MiddlewareName = "recovery"
+	MiddlewareIPRate     MiddlewareName = "ipRate"
 )
 
+// DefaultMiddlewareOrder InitMiddleware默认的中间件顺序，限流在RequestId之后，
+// 被限流的请求不再经过指标、链路和日志(包括body的读取)
+var DefaultMiddlewareOrder = []MiddlewareName{
+	MiddlewareCors,
+	MiddlewareRequestId,
+	MiddlewareIPRate,
+	MiddlewarePrometheus,
+	MiddlewareTrace,
+	MiddlewareLogger,
+	MiddlewareRecovery,
+}
+
 type Conf struct {
 	ApplicationName string
 	UsePprof        bool
+	// 为空时使用DefaultCorsConfig
+	Cors *CorsConfig
+	// 为空时不限流
+	IPRate     *IPRateConf
+	Prometheus PrometheusConf
+	Logger     LoggerConf
+	Recovery   RecoveryConf
+	RequestId  RequestIdConf
+	// otelgin.Middleware的选项
+	TraceOptions []otelgin.Option `mapstructure:"-"`
+
+	// 关闭的中间件
+	Disabled []MiddlewareName `mapstructure:"disabled"`
+	// 中间件顺序，为空时使用DefaultMiddlewareOrder，未列出的中间件不会安装
+	Order []MiddlewareName `mapstructure:"order"`
+	// 在某个中间件之前/之后插入自定义中间件，对应的中间件被关闭时依然生效
+	Before map[MiddlewareName][]gin.HandlerFunc `mapstructure:"-"`
+	After  map[MiddlewareName][]gin.HandlerFunc `mapstructure:"-"`
 }
 
 func InitMiddleware(r *gin.Engine, conf Conf) {
-	r.Use(Cors())
-	//r.Use(gzip.Gzip(gzip.DefaultCompression))
-	// Set X-Request-Id header
-	r.Use(RequestId())
 	if conf.UsePprof {
 		pprof.Register(r)
 	}
-	p := NewPrometheus("")
-	p.Use(r)
-	r.Use(otelgin.Middleware(conf.ApplicationName))
-	// 日志处理
-	r.Use(LoggerToFile())
-	r.Use(panicApi)
+
+	order := conf.Order
+	if len(order) == 0 {
+		order = DefaultMiddlewareOrder
+	}
+	disabled := make(map[MiddlewareName]bool, len(conf.Disabled))
+	for _, name := range conf.Disabled {
+		disabled[name] = true
+	}
+
+	var p *Prometheus
+	if !disabled[MiddlewarePrometheus] {
+		p = NewPrometheusWithConf(conf.Prometheus)
+		if conf.Prometheus.ListenAddress != "" {
+			p.SetListenAddress(conf.Prometheus.ListenAddress)
+		}
+	}
+
+	for _, name := range order {
+		r.Use(conf.Before[name]...)
+		if !disabled[name] {
+			useMiddleware(r, name, conf, p)
+		}
+		r.Use(conf.After[name]...)
+	}
 }
 
-func panicApi(c *gin.Context) {
-	defer func() {
-		if r := recover(); r != nil {
-			zLog.TraceError(
-				c.Request.Context(),
-				"HttpPanic",
-				zap.Any("panic", r),
-				zap.Any("url", c.Request.URL),
-				zap.Stack("stack"),
-			)
-			c.AbortWithStatusJSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"code":    500,
-					"message": "Internal Server Error",
-					"success": false,
-				},
-			)
+func useMiddleware(r *gin.Engine, name MiddlewareName, conf Conf, p *Prometheus) {
+	switch name {
+	case MiddlewareCors:
+		corsConf := DefaultCorsConfig()
+		if conf.Cors != nil {
+			corsConf = *conf.Cors
+		}
+		r.Use(CorsWithConfig(corsConf))
+	case MiddlewareRequestId:
+		// Set X-Request-Id header
+		r.Use(RequestIdWithConf(conf.RequestId))
+	case MiddlewarePrometheus:
+		if len(conf.Prometheus.Accounts) > 0 {
+			p.UseWithAuth(r, conf.Prometheus.Accounts)
+		} else {
+			p.Use(r)
+		}
+	case MiddlewareTrace:
+		r.Use(beforeTrace, otelgin.Middleware(conf.ApplicationName, conf.TraceOptions...), afterTrace)
+	case MiddlewareLogger:
+		// 日志处理
+		r.Use(LoggerToFileWithConf(conf.Logger))
+	case MiddlewareRecovery:
+		recoveryConf := conf.Recovery
+		if recoveryConf.Prometheus == nil {
+			recoveryConf.Prometheus = p
 		}
-	}()
-	c.Next()
+		r.Use(Recovery(recoveryConf))
+	case MiddlewareIPRate:
+		if conf.IPRate == nil {
+			return
+		}
+		rateConf := *conf.IPRate
+		if rateConf.Prometheus == nil {
+			rateConf.Prometheus = p
+		}
+		r.Use(IPRateLimit(rateConf))
+	default:
+		zLog.Warn("unknown middleware", zap.String("name", string(name)))
+	}
 }
 
-func authMiddleware(c *gin.Context) {
-	if false {
-		c.AbortWithStatusJSON(
-			http.StatusUnauthorized,
-			gin.H{
-				"code":    401,
-				"message": "Invalid token",
-				"success": false,
-			},
-		)
+const spanContextKey = "harbour/spanContext"
+
+// beforeTrace 请求带tracer配置的调试header时强制采样
+func beforeTrace(c *gin.Context) {
+	if h := tracer.DebugHeader(); h != "" && tracer.IsDebugValue(c.GetHeader(h)) {
+		c.Request = c.Request.WithContext(tracer.ContextWithForceSample(c.Request.Context()))
+	}
+}
+
+// afterTrace 紧跟在trace中间件之后，otelgin在返回前会还原请求的context，
+// 把span写入gin.Context供外层的Prometheus等中间件使用，并给span带上请求ID
+func afterTrace(c *gin.Context) {
+	c.Set(spanContextKey, trace.SpanContextFromContext(c.Request.Context()))
+	annotateRequestId(c)
+}
+
+func spanContextFromGin(c *gin.Context) trace.SpanContext {
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+		return sc
+	}
+	if v, ok := c.Get(spanContextKey); ok {
+		sc, _ := v.(trace.SpanContext)
+		return sc
 	}
-	c.Next()
+	return trace.SpanContext{}
 }
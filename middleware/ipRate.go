@@ -1 +1,334 @@
 package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type RateLimitAlgorithm string
+
+const (
+	RateLimitTokenBucket   RateLimitAlgorithm = "tokenBucket"
+	RateLimitSlidingWindow RateLimitAlgorithm = "slidingWindow"
+
+	RateLimitBackendLocal = "local"
+	RateLimitBackendRedis = "redis"
+
+	defaultRateLimit  = 100
+	defaultRateWindow = time.Second
+)
+
+var rateLimitCnt = &Metric{
+	ID:          "rateLimitCnt",
+	Name:        "rate_limit_requests_total",
+	Description: "How many requests passed through the rate limiter, partitioned by result and backend.",
+	Type:        "counter_vec",
+	Args:        []string{"result", "backend"},
+}
+
+// RateLimitKeyFunc 返回限流的维度，返回空字符串时不限流
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitResult 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // 被拒绝时，距离下一次可通过的时间
+	ResetAfter time.Duration // 距离配额完全恢复的时间
+	Backend    string        // 实际做出判断的后端，local或redis
+}
+
+// RateLimiter 限流器，key为限流维度（如客户端IP）
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+type IPRateConf struct {
+	Algorithm RateLimitAlgorithm `mapstructure:"algorithm"`
+	// 每个Window内允许的请求数
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+	// 令牌桶容量，默认等于Limit，仅对tokenBucket生效
+	Burst int `mapstructure:"burst"`
+	// local或redis，redis使用harbour redis包初始化的Cluster或Client，多副本共享配额
+	Backend        string `mapstructure:"backend"`
+	RedisKeyPrefix string `mapstructure:"redisKeyPrefix"`
+
+	// 默认按c.ClientIP()限流
+	KeyFunc RateLimitKeyFunc `mapstructure:"-"`
+	// 自定义限流器，设置后忽略Algorithm和Backend
+	Limiter RateLimiter `mapstructure:"-"`
+	// 设置后通过该实例的registry上报通过/拒绝的请求数
+	Prometheus *Prometheus `mapstructure:"-"`
+}
+
+func (conf IPRateConf) withDefaults() IPRateConf {
+	if conf.Limit <= 0 {
+		conf.Limit = defaultRateLimit
+	}
+	if conf.Window <= 0 {
+		conf.Window = defaultRateWindow
+	}
+	if conf.Burst <= 0 {
+		conf.Burst = conf.Limit
+	}
+	if conf.KeyFunc == nil {
+		conf.KeyFunc = KeyByClientIP
+	}
+	if conf.RedisKeyPrefix == "" {
+		conf.RedisKeyPrefix = defaultRedisRateKeyPrefix
+	}
+	return conf
+}
+
+// KeyByClientIP 按客户端IP限流
+func KeyByClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// KeyByContextValue 按gin.Context中的值限流，比如ParseJWTToken写入的claim，取不到时回退到客户端IP
+func KeyByContextValue(key string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		if v := c.GetString(key); v != "" {
+			return key + ":" + v
+		}
+		return KeyByClientIP(c)
+	}
+}
+
+// NewLocalRateLimiter 按Algorithm创建进程内限流器
+func NewLocalRateLimiter(conf IPRateConf) RateLimiter {
+	conf = conf.withDefaults()
+	switch conf.Algorithm {
+	case RateLimitSlidingWindow:
+		return newSlidingWindowLimiter(conf.Limit, conf.Window)
+	default:
+		return newTokenBucketLimiter(conf.Limit, conf.Burst, conf.Window)
+	}
+}
+
+// IPRateLimit 按客户端限流，超出时返回429
+func IPRateLimit(conf IPRateConf) gin.HandlerFunc {
+	conf = conf.withDefaults()
+	limiter := conf.Limiter
+	if limiter == nil {
+		if conf.Backend == RateLimitBackendRedis {
+			limiter = NewRedisRateLimiter(conf, nil)
+		} else {
+			limiter = NewLocalRateLimiter(conf)
+		}
+	}
+	var counter *prometheus.CounterVec
+	if conf.Prometheus != nil {
+		counter = conf.Prometheus.registerMetric(rateLimitCnt).(*prometheus.CounterVec)
+	}
+
+	return func(c *gin.Context) {
+		key := conf.KeyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+		res, err := limiter.Allow(c.Request.Context(), key)
+		if err != nil {
+			// 限流器异常时放行，避免影响正常业务
+			c.Next()
+			return
+		}
+
+		if counter != nil {
+			result := "allowed"
+			if !res.Allowed {
+				result = "denied"
+			}
+			counter.WithLabelValues(result, res.Backend).Inc()
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(
+				http.StatusTooManyRequests,
+				gin.H{
+					"code":    429,
+					"message": "Too Many Requests",
+					"success": false,
+				},
+			)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tokenBucketLimiter 令牌桶，每个key一个桶，按rate匀速补充令牌
+type tokenBucketLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	limit     int
+	burst     float64
+	rate      float64 // 每秒补充的令牌数
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucketLimiter(limit, burst int, window time.Duration) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		buckets: make(map[string]*tokenBucket),
+		limit:   burst,
+		burst:   float64(burst),
+		rate:    float64(limit) / window.Seconds(),
+		now:     time.Now,
+	}
+}
+
+func (l *tokenBucketLimiter) Allow(_ context.Context, key string) (RateLimitResult, error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	res := RateLimitResult{Limit: l.limit, Backend: RateLimitBackendLocal}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.secondsToDuration((1 - b.tokens) / l.rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.ResetAfter = l.secondsToDuration((l.burst - b.tokens) / l.rate)
+	return res, nil
+}
+
+// sweep 清理已经补满的桶，避免key无限增长
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	full := l.secondsToDuration(l.burst / l.rate)
+	if now.Sub(l.lastSweep) < full {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *tokenBucketLimiter) secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// slidingWindowLimiter 滑动窗口计数，用上一个窗口的计数按重叠比例加权估算当前窗口的请求数
+type slidingWindowLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*slidingWindow
+	limit     int
+	window    time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type slidingWindow struct {
+	start    time.Time // 当前窗口的开始时间
+	prev     int
+	curr     int
+	lastSeen time.Time
+}
+
+func newSlidingWindowLimiter(limit int, window time.Duration) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		windows: make(map[string]*slidingWindow),
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+	}
+}
+
+func (l *slidingWindowLimiter) Allow(_ context.Context, key string) (RateLimitResult, error) {
+	now := l.now()
+	start := now.Truncate(l.window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	w, ok := l.windows[key]
+	if !ok {
+		w = &slidingWindow{start: start}
+		l.windows[key] = w
+	}
+	switch {
+	case start.Equal(w.start):
+	case start.Sub(w.start) == l.window:
+		w.prev, w.curr, w.start = w.curr, 0, start
+	default:
+		w.prev, w.curr, w.start = 0, 0, start
+	}
+	w.lastSeen = now
+
+	elapsed := now.Sub(start)
+	weight := float64(l.window-elapsed) / float64(l.window)
+	count := float64(w.prev)*weight + float64(w.curr)
+
+	res := RateLimitResult{Limit: l.limit, ResetAfter: l.window - elapsed, Backend: RateLimitBackendLocal}
+	if count+1 <= float64(l.limit) {
+		w.curr++
+		count++
+		res.Allowed = true
+	} else if w.prev > 0 && float64(w.curr) < float64(l.limit) {
+		// 需要等上一个窗口的权重下降到足以容纳一个请求
+		need := (count + 1 - float64(l.limit)) / float64(w.prev)
+		res.RetryAfter = time.Duration(need * float64(l.window))
+	} else {
+		res.RetryAfter = l.window - elapsed
+	}
+	if w.prev > 0 {
+		res.ResetAfter += l.window
+	}
+	res.Remaining = int(math.Max(0, float64(l.limit)-math.Ceil(count)))
+	return res, nil
+}
+
+// sweep 清理超过两个窗口未访问的key
+func (l *slidingWindowLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for k, w := range l.windows {
+		if now.Sub(w.lastSeen) >= 2*l.window {
+			delete(l.windows, k)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	harbourRedis "github.com/RollNA/harbour/redis"
+	"github.com/RollNA/harbour/zLog"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRedisRateKeyPrefix = "harbour:ratelimit:"
+	// redis异常后，在该时间内直接使用本地限流，避免每个请求都等待redis超时
+	redisRateFallbackCooldown = 5 * time.Second
+)
+
+var errUnexpectedRateScriptResult = errors.New("unexpected rate limit script result")
+
+// GCRA（通用信元速率算法），等价于令牌桶，只需为每个key保存一个理论到达时间(TAT)
+// KEYS[1] key; ARGV[1] burst; ARGV[2] 每个window的请求数; ARGV[3] window(秒)
+// 返回 {allowed, remaining, retry_after(秒), reset_after(秒)}
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+
+local emission_interval = period / rate
+local burst_offset = emission_interval * burst
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+  tat = now
+end
+
+local new_tat = tat + emission_interval
+local diff = now - (new_tat - burst_offset)
+
+if diff < 0 then
+  return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local reset_after = new_tat - now
+redis.call("SET", key, tostring(new_tat), "PX", math.ceil(reset_after * 1000))
+return {1, math.floor(diff / emission_interval), "0", tostring(reset_after)}
+`)
+
+// 滑动日志，用有序集合记录window内每个请求的时间戳
+// KEYS[1] key; ARGV[1] limit; ARGV[2] window(毫秒); ARGV[3] member
+// 返回 {allowed, remaining, retry_after(秒), reset_after(秒)}
+var slidingLogScript = redis.NewScript(`
+redis.replicate_commands()
+
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+
+if count >= limit then
+  local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+  local retry_after = window
+  if oldest[2] then
+    retry_after = tonumber(oldest[2]) + window - now
+  end
+  return {0, 0, tostring(retry_after / 1000), tostring(window / 1000)}
+end
+
+redis.call("ZADD", key, now, ARGV[3])
+redis.call("PEXPIRE", key, window)
+return {1, limit - count - 1, "0", tostring(window / 1000)}
+`)
+
+// RedisRateLimiter 基于redis的分布式限流，多副本共享同一份配额。
+// redis不可用时回退到进程内限流
+type RedisRateLimiter struct {
+	client   redis.Scripter
+	conf     IPRateConf
+	fallback RateLimiter
+
+	mu            sync.Mutex
+	fallbackUntil time.Time
+	noClientOnce  sync.Once
+}
+
+// NewRedisRateLimiter 创建分布式限流器，client为空时每次请求依次使用harbour redis包的Cluster和Client，
+// 中间件可以在redis初始化之前创建
+func NewRedisRateLimiter(conf IPRateConf, client redis.Scripter) *RedisRateLimiter {
+	conf = conf.withDefaults()
+	return &RedisRateLimiter{
+		client:   client,
+		conf:     conf,
+		fallback: NewLocalRateLimiter(conf),
+	}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	client := l.scripter()
+	if client == nil {
+		l.noClientOnce.Do(func() {
+			zLog.TraceWarn(ctx, "redis rate limiter has no redis client, fallback to local until redis is initialized")
+		})
+		return l.fallback.Allow(ctx, key)
+	}
+	if l.inFallback() {
+		return l.fallback.Allow(ctx, key)
+	}
+
+	res, err := l.eval(ctx, client, l.conf.RedisKeyPrefix+key)
+	if err != nil {
+		l.startFallback(ctx, err)
+		return l.fallback.Allow(ctx, key)
+	}
+	return res, nil
+}
+
+func (l *RedisRateLimiter) scripter() redis.Scripter {
+	if l.client != nil {
+		return l.client
+	}
+	if harbourRedis.Cluster != nil {
+		return harbourRedis.Cluster
+	}
+	if harbourRedis.Client != nil {
+		return harbourRedis.Client
+	}
+	return nil
+}
+
+func (l *RedisRateLimiter) eval(ctx context.Context, client redis.Scripter, key string) (RateLimitResult, error) {
+	var (
+		values []interface{}
+		err    error
+		limit  int
+	)
+	switch l.conf.Algorithm {
+	case RateLimitSlidingWindow:
+		limit = l.conf.Limit
+		values, err = slidingLogScript.Run(ctx, client, []string{key},
+			l.conf.Limit, l.conf.Window.Milliseconds(), uuid.NewString()).Slice()
+	default:
+		limit = l.conf.Burst
+		values, err = gcraScript.Run(ctx, client, []string{key},
+			l.conf.Burst, l.conf.Limit, l.conf.Window.Seconds()).Slice()
+	}
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, errUnexpectedRateScriptResult
+	}
+
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	return RateLimitResult{
+		Allowed:    allowed == 1,
+		Limit:      limit,
+		Remaining:  int(remaining),
+		RetryAfter: parseLuaSeconds(values[2]),
+		ResetAfter: parseLuaSeconds(values[3]),
+		Backend:    RateLimitBackendRedis,
+	}, nil
+}
+
+func (l *RedisRateLimiter) inFallback() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.fallbackUntil)
+}
+
+func (l *RedisRateLimiter) startFallback(ctx context.Context, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Now().Before(l.fallbackUntil) {
+		return
+	}
+	l.fallbackUntil = time.Now().Add(redisRateFallbackCooldown)
+	zLog.TraceWarn(ctx, "redis rate limiter unavailable, fallback to local", zap.Error(err))
+}
+
+func parseLuaSeconds(v interface{}) time.Duration {
+	s, _ := v.(string)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
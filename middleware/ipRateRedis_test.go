@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedisLimiter 脚本中的TIME使用clock的时间
+func newTestRedisLimiter(t *testing.T, conf IPRateConf, clock *fakeClock) *RedisRateLimiter {
+	m := miniredis.RunT(t)
+	m.SetTime(clock.t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisRateLimiter(conf, &miniredisClock{Client: client, m: m, clock: clock})
+}
+
+// miniredisClock 每次执行脚本前同步miniredis的时间
+type miniredisClock struct {
+	*redis.Client
+	m     *miniredis.Miniredis
+	clock *fakeClock
+}
+
+func (c *miniredisClock) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	c.m.SetTime(c.clock.t)
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func (c *miniredisClock) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	c.m.SetTime(c.clock.t)
+	return c.Client.Eval(ctx, script, keys, args...)
+}
+
+func TestRedisRateLimiterGCRA(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := newTestRedisLimiter(t, IPRateConf{Limit: 2, Window: time.Second}, clock)
+
+	assertRateCases(t, l, clock, []rateCase{
+		{0, true, 1, 0, 500 * time.Millisecond},
+		{0, true, 0, 0, time.Second},
+		{0, false, 0, 500 * time.Millisecond, time.Second},
+		{250 * time.Millisecond, false, 0, 250 * time.Millisecond, 750 * time.Millisecond},
+		{250 * time.Millisecond, true, 0, 0, time.Second},
+		{time.Minute, true, 1, 0, 500 * time.Millisecond},
+	})
+}
+
+func TestRedisRateLimiterSlidingLog(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := newTestRedisLimiter(t, IPRateConf{Algorithm: RateLimitSlidingWindow, Limit: 2, Window: time.Second}, clock)
+
+	assertRateCases(t, l, clock, []rateCase{
+		{0, true, 1, 0, time.Second},
+		{250 * time.Millisecond, true, 0, 0, time.Second},
+		// 等最早的请求移出窗口
+		{250 * time.Millisecond, false, 0, 500 * time.Millisecond, time.Second},
+		{500 * time.Millisecond, true, 0, 0, time.Second},
+		{0, false, 0, 250 * time.Millisecond, time.Second},
+	})
+	res, err := l.Allow(context.Background(), "k")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimitBackendRedis, res.Backend)
+}
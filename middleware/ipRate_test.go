@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+type rateCase struct {
+	advance    time.Duration
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+	resetAfter time.Duration
+}
+
+func assertRateCases(t *testing.T, l RateLimiter, clock *fakeClock, cases []rateCase) {
+	for i, tc := range cases {
+		clock.advance(tc.advance)
+		res, err := l.Allow(context.Background(), "k")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.allowed, res.Allowed, "step %d", i)
+		assert.Equal(t, tc.remaining, res.Remaining, "step %d", i)
+		assert.Equal(t, tc.retryAfter, res.RetryAfter, "step %d", i)
+		assert.Equal(t, tc.resetAfter, res.ResetAfter, "step %d", i)
+	}
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newTokenBucketLimiter(2, 2, time.Second)
+	l.now = clock.now
+
+	assertRateCases(t, l, clock, []rateCase{
+		// 突发容量为2
+		{0, true, 1, 0, 500 * time.Millisecond},
+		{0, true, 0, 0, time.Second},
+		{0, false, 0, 500 * time.Millisecond, time.Second},
+		// 每秒补充2个令牌
+		{250 * time.Millisecond, false, 0, 250 * time.Millisecond, 750 * time.Millisecond},
+		{250 * time.Millisecond, true, 0, 0, time.Second},
+		// 长时间空闲后最多补满到突发容量
+		{time.Minute, true, 1, 0, 500 * time.Millisecond},
+	})
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newSlidingWindowLimiter(10, time.Second)
+	l.now = clock.now
+
+	cases := make([]rateCase, 0, 16)
+	for i := 0; i < 10; i++ {
+		cases = append(cases, rateCase{0, true, 9 - i, 0, time.Second})
+	}
+	cases = append(cases,
+		// 当前窗口已满，没有上一个窗口时等到窗口结束
+		rateCase{0, false, 0, time.Second, time.Second},
+		// 进入下一个窗口的1/4处，上一个窗口的10个请求按0.75加权计为7.5
+		rateCase{1250 * time.Millisecond, true, 1, 0, 1750 * time.Millisecond},
+		rateCase{0, true, 0, 0, 1750 * time.Millisecond},
+		// 7.5+2+1超出上限0.5，需要上一个窗口的权重再下降0.05
+		rateCase{0, false, 0, 50 * time.Millisecond, 1750 * time.Millisecond},
+		rateCase{50 * time.Millisecond, true, 0, 0, 1700 * time.Millisecond},
+		// 超过一个窗口没有请求时两个窗口都清零
+		rateCase{2 * time.Second, true, 9, 0, 700 * time.Millisecond},
+	)
+	assertRateCases(t, l, clock, cases)
+}
+
+func TestIPRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newTokenBucketLimiter(1, 2, 2*time.Second)
+	l.now = clock.now
+
+	r := gin.New()
+	r.Use(IPRateLimit(IPRateConf{Limiter: l}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		code       int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{http.StatusOK, "1", "2", ""},
+		{http.StatusOK, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	}
+	for i, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, tc.code, w.Code, "step %d", i)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"), "step %d", i)
+		assert.Equal(t, tc.remaining, w.Header().Get("X-RateLimit-Remaining"), "step %d", i)
+		assert.Equal(t, tc.reset, w.Header().Get("X-RateLimit-Reset"), "step %d", i)
+		assert.Equal(t, tc.retryAfter, w.Header().Get("Retry-After"), "step %d", i)
+		if tc.code == http.StatusTooManyRequests {
+			assert.JSONEq(t, `{"code":429,"message":"Too Many Requests","success":false}`, w.Body.String())
+		}
+	}
+}
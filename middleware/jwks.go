@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RollNA/harbour/routine"
+	"github.com/RollNA/harbour/zLog"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = time.Minute
+	defaultJWKSTimeout            = 5 * time.Second
+)
+
+type JWKSConf struct {
+	URL string `mapstructure:"url"`
+	// 定时刷新间隔，默认1小时
+	RefreshInterval time.Duration `mapstructure:"refreshInterval"`
+	// 遇到未知kid时触发刷新的最小间隔，防止伪造kid打爆身份服务，默认1分钟
+	MinRefreshInterval time.Duration `mapstructure:"minRefreshInterval"`
+
+	HTTPClient *http.Client `mapstructure:"-"`
+}
+
+// JWKSProvider 从JWKS地址拉取并缓存公钥，实现JWTKeyProvider
+type JWKSProvider struct {
+	conf JWKSConf
+
+	mu   sync.RWMutex
+	keys map[string]jwk
+
+	// 串行化刷新，lastFetch为最近一次请求JWKS的时间
+	fetchMu   sync.Mutex
+	lastFetch time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type jwk struct {
+	alg string
+	key any
+}
+
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// NewJWKSProvider 立即拉取一次JWKS，并按RefreshInterval在后台刷新，不再使用时调用Close
+func NewJWKSProvider(ctx context.Context, conf JWKSConf) (*JWKSProvider, error) {
+	if conf.URL == "" {
+		return nil, errors.New("jwks url is empty")
+	}
+	if conf.RefreshInterval <= 0 {
+		conf.RefreshInterval = defaultJWKSRefreshInterval
+	}
+	if conf.MinRefreshInterval <= 0 {
+		conf.MinRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+	if conf.HTTPClient == nil {
+		conf.HTTPClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+
+	p := &JWKSProvider{
+		conf: conf,
+		keys: make(map[string]jwk),
+		stop: make(chan struct{}),
+	}
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	routine.GoSafe(p.refreshLoop)
+	return p, nil
+}
+
+// Key 按kid返回公钥，kid未知时在限频范围内重新拉取JWKS
+func (p *JWKSProvider) Key(ctx context.Context, header JWTHeader) (any, error) {
+	if k, ok := p.lookup(header); ok {
+		return p.checkAlg(k, header)
+	}
+
+	p.fetchMu.Lock()
+	// 等锁期间可能已经被其他请求刷新过
+	if k, ok := p.lookup(header); ok {
+		p.fetchMu.Unlock()
+		return p.checkAlg(k, header)
+	}
+	if time.Since(p.lastFetch) >= p.conf.MinRefreshInterval {
+		// 不跟随请求的ctx，客户端断开时刷新不会被取消，也不会因此占用限频
+		refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultJWKSTimeout)
+		err := p.refreshLocked(refreshCtx)
+		cancel()
+		if err != nil {
+			zLog.TraceWarn(ctx, "jwks refresh failed", zap.String("url", p.conf.URL), zap.Error(err))
+		}
+	}
+	p.fetchMu.Unlock()
+
+	if k, ok := p.lookup(header); ok {
+		return p.checkAlg(k, header)
+	}
+	return nil, fmt.Errorf("%w: kid %q", ErrJWTKeyNotFound, header.Kid)
+}
+
+// Refresh 立即拉取JWKS
+func (p *JWKSProvider) Refresh(ctx context.Context) error {
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+	return p.refreshLocked(ctx)
+}
+
+// Close 停止后台刷新
+func (p *JWKSProvider) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *JWKSProvider) lookup(header JWTHeader) (jwk, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if header.Kid == "" && len(p.keys) == 1 {
+		// 没有kid时，只有一个key的JWKS可以直接使用
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[header.Kid]
+	return k, ok
+}
+
+func (p *JWKSProvider) checkAlg(k jwk, header JWTHeader) (any, error) {
+	if k.alg != "" && k.alg != header.Alg {
+		return nil, fmt.Errorf("%w: kid %q is for %s", ErrJWTKeyNotFound, header.Kid, k.alg)
+	}
+	return k.key, nil
+}
+
+func (p *JWKSProvider) refreshLoop() {
+	ticker := time.NewTicker(p.conf.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Refresh(context.Background()); err != nil {
+				zLog.Warn("jwks refresh failed", zap.String("url", p.conf.URL), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (p *JWKSProvider) refreshLocked(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.conf.URL, nil)
+	if err != nil {
+		return err
+	}
+	prev := p.lastFetch
+	p.lastFetch = time.Now()
+	resp, err := p.conf.HTTPClient.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// 调用方取消，没有完成对IdP的请求，不计入限频
+			p.lastFetch = prev
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks fetch: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks decode: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := raw.publicKey()
+		if err != nil {
+			zLog.TraceWarn(ctx, "jwks skip invalid key", zap.String("kid", raw.Kid), zap.Error(err))
+			continue
+		}
+		keys[raw.Kid] = jwk{alg: raw.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		// 保留旧的key，避免身份服务异常时所有请求都校验失败
+		return errors.New("jwks contains no usable keys")
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (k jwkJSON) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
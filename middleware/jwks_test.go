@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testJWKSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int32
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	s := &testJWKSServer{keys: make(map[string]*rsa.PrivateKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var keys []map[string]string
+		for kid, k := range s.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testJWKSServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	s.mu.Lock()
+	s.keys[kid] = k
+	s.mu.Unlock()
+	return k
+}
+
+func signRS256(t *testing.T, k *rsa.PrivateKey, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	assert.NoError(t, err)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWKSProviderRotation(t *testing.T) {
+	s := newTestJWKSServer(t)
+	k1 := s.addKey(t, "k1")
+
+	p, err := NewJWKSProvider(context.Background(), JWKSConf{URL: s.URL, MinRefreshInterval: time.Hour})
+	assert.NoError(t, err)
+	defer p.Close()
+	v := NewJWTVerifier(JWTConf{KeyProvider: p, Issuer: "idp"})
+
+	claims := map[string]any{"iss": "idp", "sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+	got, err := v.Verify(context.Background(), signRS256(t, k1, "k1", claims))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", got.Subject)
+
+	// 未知kid触发一次刷新
+	k2 := s.addKey(t, "k2")
+	p.lastFetch = time.Time{}
+	_, err = v.Verify(context.Background(), signRS256(t, k2, "k2", claims))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.fetches))
+
+	// 限频期间不再刷新
+	_, err = v.Verify(context.Background(), signRS256(t, k2, "k3", claims))
+	assert.ErrorIs(t, err, ErrJWTKeyNotFound)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.fetches))
+
+	// 用错误的key签名
+	_, err = v.Verify(context.Background(), signRS256(t, k1, "k2", claims))
+	assert.ErrorIs(t, err, ErrJWTInvalidSignature)
+}
+
+func TestVerifyJWTTokenWithJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestJWKSServer(t)
+	k := s.addKey(t, "k1")
+	p, err := NewJWKSProvider(context.Background(), JWKSConf{URL: s.URL})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	r := gin.New()
+	r.Use(VerifyJWTToken(JWTConf{KeyProvider: p, Audience: []string{"api"}}))
+	r.GET("/", func(c *gin.Context) {
+		claims, _ := ClaimsFromContext(c)
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	cases := []struct {
+		name   string
+		claims map[string]any
+		code   int
+		reason string
+	}{
+		{"ok", map[string]any{"sub": "bob", "aud": "api", "exp": time.Now().Add(time.Minute).Unix()}, http.StatusOK, ""},
+		{"expired", map[string]any{"sub": "bob", "aud": "api", "exp": time.Now().Add(-time.Minute).Unix()}, http.StatusUnauthorized, "token_expired"},
+		{"audience", map[string]any{"sub": "bob", "aud": "other"}, http.StatusUnauthorized, "invalid_audience"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signRS256(t, k, "k1", tc.claims))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+		if tc.reason != "" {
+			var body map[string]any
+			_ = json.Unmarshal(w.Body.Bytes(), &body)
+			assert.Equal(t, tc.reason, body["reason"], tc.name)
+		}
+	}
+}
+
+func TestJWKSProviderRefreshDetachedFromRequest(t *testing.T) {
+	s := newTestJWKSServer(t)
+	s.addKey(t, "k1")
+	p, err := NewJWKSProvider(context.Background(), JWKSConf{URL: s.URL, MinRefreshInterval: time.Hour})
+	assert.NoError(t, err)
+	defer p.Close()
+	k2 := s.addKey(t, "k2")
+	p.lastFetch = time.Time{}
+
+	// 客户端已经断开，未知kid的刷新依然完成
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v := NewJWTVerifier(JWTConf{KeyProvider: p})
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+	_, err = v.Verify(ctx, signRS256(t, k2, "k2", claims))
+	assert.NoError(t, err)
+
+	// 调用方取消的Refresh不占用限频
+	p.lastFetch = time.Time{}
+	assert.Error(t, p.Refresh(ctx))
+	assert.True(t, p.lastFetch.IsZero())
+}
@@ -2,29 +2,22 @@ package middleware
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ParseJWT 自定义token解析，内置校验见VerifyJWTToken
 type ParseJWT func(token string) (map[string]any, bool)
 
 func ParseJWTToken(key string, parse ParseJWT) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if len(key) == 0 {
-			key = "Authorization"
-		}
-		tokenString := ctx.GetHeader(key)
-
-		if strings.Contains(tokenString, "Bearer ") {
-			tokenString = strings.Split(tokenString, "Bearer ")[1]
-		}
+		tokenString := tokenFromHeader(ctx, key)
 		input, valid := parse(tokenString)
 		if valid {
 			for k, v := range input {
 				ctx.Set(k, v)
 			}
-			ctx.Set("JWTToken", tokenString)
+			ctx.Set(JWTTokenKey, tokenString)
 		}
 		ctx.Next()
 	}
@@ -32,13 +25,7 @@ func ParseJWTToken(key string, parse ParseJWT) gin.HandlerFunc {
 
 func ValidateJWTToken(key string, parse ParseJWT) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if len(key) == 0 {
-			key = "Authorization"
-		}
-		tokenString := ctx.GetHeader(key)
-		if strings.Contains(tokenString, "Bearer ") {
-			tokenString = strings.Split(tokenString, "Bearer ")[1]
-		}
+		tokenString := tokenFromHeader(ctx, key)
 		input, valid := parse(tokenString)
 		if !valid {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -48,7 +35,7 @@ func ValidateJWTToken(key string, parse ParseJWT) gin.HandlerFunc {
 		for k, v := range input {
 			ctx.Set(k, v)
 		}
-		ctx.Set("JWTToken", tokenString)
+		ctx.Set(JWTTokenKey, tokenString)
 		ctx.Next()
 	}
 }
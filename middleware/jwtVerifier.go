@@ -0,0 +1,475 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// JWTClaimsKey gin.Context中保存*JWTClaims的key
+	JWTClaimsKey = "JWTClaims"
+	// JWTTokenKey gin.Context中保存原始token的key
+	JWTTokenKey = "JWTToken"
+
+	defaultJWTHeader = "Authorization"
+)
+
+var (
+	ErrJWTMissing              = errors.New("token missing")
+	ErrJWTMalformed            = errors.New("token malformed")
+	ErrJWTUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrJWTKeyNotFound          = errors.New("signing key not found")
+	ErrJWTInvalidSignature     = errors.New("invalid signature")
+	ErrJWTExpired              = errors.New("token expired")
+	ErrJWTMissingExp           = errors.New("token missing exp")
+	ErrJWTNotYetValid          = errors.New("token not valid yet")
+	ErrJWTIssuedInFuture       = errors.New("token used before issued")
+	ErrJWTInvalidIssuer        = errors.New("invalid issuer")
+	ErrJWTInvalidAudience      = errors.New("invalid audience")
+	ErrJWTInvalid              = errors.New("invalid token")
+)
+
+// jwtErrorReasons 401响应中的reason字段，message固定使用对应ErrJWT*的文案，不返回包装的内部细节
+var jwtErrorReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrJWTMissing, "token_missing"},
+	{ErrJWTMalformed, "token_malformed"},
+	{ErrJWTUnsupportedAlgorithm, "unsupported_algorithm"},
+	{ErrJWTKeyNotFound, "key_not_found"},
+	{ErrJWTInvalidSignature, "invalid_signature"},
+	{ErrJWTExpired, "token_expired"},
+	{ErrJWTMissingExp, "exp_missing"},
+	{ErrJWTNotYetValid, "token_not_yet_valid"},
+	{ErrJWTIssuedInFuture, "token_used_before_issued"},
+	{ErrJWTInvalidIssuer, "invalid_issuer"},
+	{ErrJWTInvalidAudience, "invalid_audience"},
+}
+
+// JWTHeader token的JOSE头
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// JWTKeyProvider 根据token头(alg、kid)返回验签公钥或密钥
+type JWTKeyProvider interface {
+	Key(ctx context.Context, header JWTHeader) (any, error)
+}
+
+// JWTClaims 校验通过的token中的声明
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// 所有声明，包括上面的标准声明
+	Raw map[string]any
+}
+
+// Get 读取任意声明
+func (c *JWTClaims) Get(key string) (any, bool) {
+	v, ok := c.Raw[key]
+	return v, ok
+}
+
+type JWTConf struct {
+	// token所在的header，默认Authorization，支持"Bearer "前缀
+	Header string `mapstructure:"header"`
+	// 允许的签名算法，为空时允许HS256/384/512、RS256/384/512、ES256/384/512、EdDSA，
+	// 实际算法还必须与密钥类型匹配
+	Algorithms []string      `mapstructure:"algorithms"`
+	Issuer     string        `mapstructure:"issuer"`
+	Audience   []string      `mapstructure:"audience"`
+	ClockSkew  time.Duration `mapstructure:"clockSkew"`
+	// 是否要求token必须带exp
+	RequireExp bool `mapstructure:"requireExp"`
+
+	// 静态密钥：HS*使用[]byte或string，RS*使用*rsa.PublicKey，ES*使用*ecdsa.PublicKey，EdDSA使用ed25519.PublicKey
+	Key any `mapstructure:"-"`
+	// 按kid查找密钥，如NewJWKSProvider，设置后忽略Key
+	KeyProvider JWTKeyProvider `mapstructure:"-"`
+	// 兼容原有的自定义解析，设置后不再做内置校验
+	ParseJWT ParseJWT `mapstructure:"-"`
+}
+
+type JWTVerifier struct {
+	conf       JWTConf
+	algorithms map[string]struct{}
+	now        func() time.Time
+}
+
+func NewJWTVerifier(conf JWTConf) *JWTVerifier {
+	if conf.Header == "" {
+		conf.Header = defaultJWTHeader
+	}
+	algs := conf.Algorithms
+	if len(algs) == 0 {
+		for alg := range jwtAlgorithms {
+			algs = append(algs, alg)
+		}
+	}
+	v := &JWTVerifier{
+		conf:       conf,
+		algorithms: make(map[string]struct{}, len(algs)),
+		now:        time.Now,
+	}
+	for _, alg := range algs {
+		v.algorithms[alg] = struct{}{}
+	}
+	return v
+}
+
+// Verify 校验签名与标准声明，失败时返回的error可以用errors.Is与ErrJWT*比较
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*JWTClaims, error) {
+	if token == "" {
+		return nil, ErrJWTMissing
+	}
+	if v.conf.ParseJWT != nil {
+		raw, valid := v.conf.ParseJWT(token)
+		if !valid {
+			return nil, ErrJWTInvalid
+		}
+		return newJWTClaims(raw), nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 segments", ErrJWTMalformed)
+	}
+	var header JWTHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrJWTMalformed, err)
+	}
+	var raw map[string]any
+	if err := decodeJWTSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrJWTMalformed, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrJWTMalformed, err)
+	}
+
+	alg, ok := jwtAlgorithms[header.Alg]
+	if _, allowed := v.algorithms[header.Alg]; !ok || !allowed {
+		return nil, fmt.Errorf("%w: %q", ErrJWTUnsupportedAlgorithm, header.Alg)
+	}
+	key := v.conf.Key
+	if v.conf.KeyProvider != nil {
+		if key, err = v.conf.KeyProvider.Key(ctx, header); err != nil {
+			return nil, err
+		}
+	}
+	if key == nil {
+		return nil, ErrJWTKeyNotFound
+	}
+	if err = alg.verify(parts[0]+"."+parts[1], sig, key); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"exp", "nbf", "iat"} {
+		// NumericDate必须是数字，否则会被当作没有该声明而跳过校验
+		if d, ok := raw[name]; ok {
+			if _, isNumber := d.(json.Number); !isNumber {
+				return nil, fmt.Errorf("%w: %s is not a NumericDate", ErrJWTMalformed, name)
+			}
+		}
+	}
+	claims := newJWTClaims(raw)
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) validateClaims(claims *JWTClaims) error {
+	now := v.now()
+	skew := v.conf.ClockSkew
+	if claims.ExpiresAt.IsZero() {
+		if v.conf.RequireExp {
+			return ErrJWTMissingExp
+		}
+	} else if now.After(claims.ExpiresAt.Add(skew)) {
+		return ErrJWTExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(skew).Before(claims.NotBefore) {
+		return ErrJWTNotYetValid
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(skew).Before(claims.IssuedAt) {
+		return ErrJWTIssuedInFuture
+	}
+	if v.conf.Issuer != "" && claims.Issuer != v.conf.Issuer {
+		return ErrJWTInvalidIssuer
+	}
+	if len(v.conf.Audience) > 0 && !intersects(v.conf.Audience, claims.Audience) {
+		return ErrJWTInvalidAudience
+	}
+	return nil
+}
+
+// VerifyJWTToken 内置校验的JWT中间件，校验失败返回401并说明原因
+func VerifyJWTToken(conf JWTConf) gin.HandlerFunc {
+	v := NewJWTVerifier(conf)
+	return func(ctx *gin.Context) {
+		token := tokenFromHeader(ctx, v.conf.Header)
+		claims, err := v.Verify(ctx.Request.Context(), token)
+		if err != nil {
+			abortJWTUnauthorized(ctx, err)
+			return
+		}
+		setJWTClaims(ctx, token, claims)
+		ctx.Next()
+	}
+}
+
+// OptionalJWTToken 有合法token时写入声明，没有或不合法时直接放行
+func OptionalJWTToken(conf JWTConf) gin.HandlerFunc {
+	v := NewJWTVerifier(conf)
+	return func(ctx *gin.Context) {
+		token := tokenFromHeader(ctx, v.conf.Header)
+		if claims, err := v.Verify(ctx.Request.Context(), token); err == nil {
+			setJWTClaims(ctx, token, claims)
+		}
+		ctx.Next()
+	}
+}
+
+// ClaimsFromContext 读取JWT中间件写入的声明
+func ClaimsFromContext(ctx *gin.Context) (*JWTClaims, bool) {
+	v, ok := ctx.Get(JWTClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*JWTClaims)
+	return claims, ok
+}
+
+func setJWTClaims(ctx *gin.Context, token string, claims *JWTClaims) {
+	// 兼容ParseJWTToken，每个声明也单独写入
+	for k, v := range claims.Raw {
+		ctx.Set(k, v)
+	}
+	ctx.Set(JWTClaimsKey, claims)
+	ctx.Set(JWTTokenKey, token)
+}
+
+func abortJWTUnauthorized(ctx *gin.Context, err error) {
+	reason, message := "invalid_token", ErrJWTInvalid.Error()
+	for _, r := range jwtErrorReasons {
+		if errors.Is(err, r.err) {
+			reason, message = r.reason, r.err.Error()
+			break
+		}
+	}
+	ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, reason))
+	ctx.AbortWithStatusJSON(
+		http.StatusUnauthorized,
+		gin.H{
+			"code":    401,
+			"message": message,
+			"reason":  reason,
+			"success": false,
+		},
+	)
+}
+
+func tokenFromHeader(ctx *gin.Context, key string) string {
+	if len(key) == 0 {
+		key = defaultJWTHeader
+	}
+	tokenString := ctx.GetHeader(key)
+	if strings.Contains(tokenString, "Bearer ") {
+		tokenString = strings.Split(tokenString, "Bearer ")[1]
+	}
+	return tokenString
+}
+
+func decodeJWTSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func newJWTClaims(raw map[string]any) *JWTClaims {
+	c := &JWTClaims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.ID, _ = raw["jti"].(string)
+	c.ExpiresAt = numericDate(raw["exp"])
+	c.NotBefore = numericDate(raw["nbf"])
+	c.IssuedAt = numericDate(raw["iat"])
+	switch aud := raw["aud"].(type) {
+	case string:
+		c.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				c.Audience = append(c.Audience, s)
+			}
+		}
+	}
+	return c
+}
+
+func numericDate(v any) time.Time {
+	var f float64
+	switch n := v.(type) {
+	case json.Number:
+		f, _ = n.Float64()
+	case float64:
+		f = n
+	case int64:
+		f = float64(n)
+	}
+	if f == 0 {
+		return time.Time{}
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type jwtAlgorithm struct {
+	hash crypto.Hash
+	// 验签实现，key类型不匹配时返回ErrJWTKeyNotFound
+	verifyFn func(hash crypto.Hash, signingInput, sig []byte, key any) error
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"HS256": {crypto.SHA256, verifyHMAC},
+	"HS384": {crypto.SHA384, verifyHMAC},
+	"HS512": {crypto.SHA512, verifyHMAC},
+	"RS256": {crypto.SHA256, verifyRSA},
+	"RS384": {crypto.SHA384, verifyRSA},
+	"RS512": {crypto.SHA512, verifyRSA},
+	"ES256": {crypto.SHA256, verifyECDSA},
+	"ES384": {crypto.SHA384, verifyECDSA},
+	"ES512": {crypto.SHA512, verifyECDSA},
+	"EdDSA": {0, verifyEdDSA},
+}
+
+// ES256必须使用P-256，ES384使用P-384，ES512使用P-521
+var ecdsaCurveBits = map[crypto.Hash]int{
+	crypto.SHA256: 256,
+	crypto.SHA384: 384,
+	crypto.SHA512: 521,
+}
+
+func (a jwtAlgorithm) verify(signingInput string, sig []byte, key any) error {
+	return a.verifyFn(a.hash, []byte(signingInput), sig, key)
+}
+
+func hashFunc(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	hasher := hashFunc(h)()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+func verifyHMAC(h crypto.Hash, signingInput, sig []byte, key any) error {
+	var secret []byte
+	switch k := key.(type) {
+	case []byte:
+		secret = k
+	case string:
+		secret = []byte(k)
+	default:
+		return fmt.Errorf("%w: HMAC requires a secret", ErrJWTKeyNotFound)
+	}
+	mac := hmac.New(hashFunc(h), secret)
+	mac.Write(signingInput)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrJWTInvalidSignature
+	}
+	return nil
+}
+
+func verifyRSA(h crypto.Hash, signingInput, sig []byte, key any) error {
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: RSA requires *rsa.PublicKey", ErrJWTKeyNotFound)
+	}
+	if err := rsa.VerifyPKCS1v15(pub, h, digest(h, signingInput), sig); err != nil {
+		return ErrJWTInvalidSignature
+	}
+	return nil
+}
+
+func verifyECDSA(h crypto.Hash, signingInput, sig []byte, key any) error {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: ECDSA requires *ecdsa.PublicKey", ErrJWTKeyNotFound)
+	}
+	bitSize := pub.Curve.Params().BitSize
+	if bitSize != ecdsaCurveBits[h] {
+		return fmt.Errorf("%w: curve P-%d does not match algorithm", ErrJWTKeyNotFound, bitSize)
+	}
+	size := (bitSize + 7) / 8
+	if len(sig) != 2*size {
+		return ErrJWTInvalidSignature
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(pub, digest(h, signingInput), r, s) {
+		return ErrJWTInvalidSignature
+	}
+	return nil
+}
+
+func verifyEdDSA(_ crypto.Hash, signingInput, sig []byte, key any) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: EdDSA requires ed25519.PublicKey", ErrJWTKeyNotFound)
+	}
+	if !ed25519.Verify(pub, signingInput, sig) {
+		return ErrJWTInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func signJWT(t *testing.T, alg string, key any, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		assert.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerifierAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+
+	cases := []struct {
+		alg     string
+		signKey any
+		key     any
+	}{
+		{"HS256", secret, secret},
+		{"ES256", ecKey, &ecKey.PublicKey},
+		{"EdDSA", edKey, edPub},
+	}
+	for _, tc := range cases {
+		token := signJWT(t, tc.alg, tc.signKey, claims)
+		got, err := NewJWTVerifier(JWTConf{Key: tc.key}).Verify(context.Background(), token)
+		assert.NoError(t, err, tc.alg)
+		if assert.NotNil(t, got, tc.alg) {
+			assert.Equal(t, "alice", got.Subject, tc.alg)
+		}
+
+		// 篡改payload后验签失败
+		tampered := signJWT(t, tc.alg, tc.signKey, map[string]any{"sub": "bob"})
+		parts := strings.Split(token, ".")
+		parts[1] = strings.Split(tampered, ".")[1]
+		_, err = NewJWTVerifier(JWTConf{Key: tc.key}).Verify(context.Background(), strings.Join(parts, "."))
+		assert.ErrorIs(t, err, ErrJWTInvalidSignature, tc.alg)
+	}
+
+	// 算法与密钥类型不匹配
+	_, err = NewJWTVerifier(JWTConf{Key: secret}).Verify(context.Background(), signJWT(t, "ES256", ecKey, claims))
+	assert.ErrorIs(t, err, ErrJWTKeyNotFound)
+	// 不在允许列表中的算法
+	_, err = NewJWTVerifier(JWTConf{Key: secret, Algorithms: []string{"RS256"}}).Verify(context.Background(), signJWT(t, "HS256", secret, claims))
+	assert.ErrorIs(t, err, ErrJWTUnsupportedAlgorithm)
+}
+
+func TestJWTVerifierClockSkew(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	v := NewJWTVerifier(JWTConf{Key: secret, ClockSkew: 30 * time.Second})
+	v.now = func() time.Time { return now }
+
+	cases := []struct {
+		name   string
+		claims map[string]any
+		err    error
+	}{
+		{"nbf within skew", map[string]any{"nbf": now.Add(20 * time.Second).Unix()}, nil},
+		{"nbf beyond skew", map[string]any{"nbf": now.Add(time.Minute).Unix()}, ErrJWTNotYetValid},
+		{"iat within skew", map[string]any{"iat": now.Add(20 * time.Second).Unix()}, nil},
+		{"iat beyond skew", map[string]any{"iat": now.Add(time.Minute).Unix()}, ErrJWTIssuedInFuture},
+		{"exp within skew", map[string]any{"exp": now.Add(-20 * time.Second).Unix()}, nil},
+		{"exp beyond skew", map[string]any{"exp": now.Add(-time.Minute).Unix()}, ErrJWTExpired},
+	}
+	for _, tc := range cases {
+		_, err := v.Verify(context.Background(), signJWT(t, "HS256", secret, tc.claims))
+		if tc.err == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.ErrorIs(t, err, tc.err, tc.name)
+		}
+	}
+}
+
+func TestJWTVerifierNumericDate(t *testing.T) {
+	secret := []byte("secret")
+	v := NewJWTVerifier(JWTConf{Key: secret, RequireExp: true})
+
+	for _, name := range []string{"exp", "nbf", "iat"} {
+		// 字符串形式的时间不能绕过校验
+		claims := map[string]any{"exp": time.Now().Add(time.Minute).Unix(), name: "1000"}
+		_, err := v.Verify(context.Background(), signJWT(t, "HS256", secret, claims))
+		assert.ErrorIs(t, err, ErrJWTMalformed, name)
+	}
+
+	_, err := v.Verify(context.Background(), signJWT(t, "HS256", secret, map[string]any{"sub": "bob"}))
+	assert.ErrorIs(t, err, ErrJWTMissingExp)
+	assert.NotErrorIs(t, err, ErrJWTExpired)
+}
+
+func TestVerifyJWTTokenMessage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(VerifyJWTToken(JWTConf{Key: []byte("secret")}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		token   string
+		reason  string
+		message string
+	}{
+		{"", "token_missing", ErrJWTMissing.Error()},
+		// 解析错误的细节不返回给调用方
+		{"a.b.c", "token_malformed", ErrJWTMalformed.Error()},
+		{signJWT(t, "HS256", []byte("other"), map[string]any{"sub": "bob"}), "invalid_signature", ErrJWTInvalidSignature.Error()},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, tc.reason)
+		var body map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		assert.Equal(t, tc.reason, body["reason"])
+		assert.Equal(t, tc.message, body["message"])
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const maskedValue = "***"
+
+// 默认脱敏的header
+var defaultMaskHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// logMasker 按字段路径脱敏JSON、表单和query参数。
+// 不带"."的路径匹配任意层级的同名字段，如"password"；
+// 带"."的路径从根开始匹配，"*"匹配任意字段名，数组不占路径层级，如"user.token"、"items.*.secret"
+type logMasker struct {
+	anywhere map[string]struct{}
+	paths    [][]string
+	headers  map[string]struct{}
+}
+
+func newLogMasker(fields, headers []string) *logMasker {
+	m := &logMasker{
+		anywhere: make(map[string]struct{}),
+		headers:  make(map[string]struct{}),
+	}
+	for _, f := range fields {
+		f = strings.ToLower(f)
+		if strings.Contains(f, ".") {
+			m.paths = append(m.paths, strings.Split(f, "."))
+		} else {
+			m.anywhere[f] = struct{}{}
+		}
+	}
+	for _, h := range append(defaultMaskHeaders, headers...) {
+		m.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	return m
+}
+
+func (m *logMasker) empty() bool {
+	return len(m.anywhere) == 0 && len(m.paths) == 0
+}
+
+func (m *logMasker) match(path []string) bool {
+	if _, ok := m.anywhere[path[len(path)-1]]; ok {
+		return true
+	}
+	for _, p := range m.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		matched := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// maskJSON 脱敏后重新序列化，不是合法JSON时返回false
+func (m *logMasker) maskJSON(data []byte) ([]byte, bool) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, false
+	}
+	if !m.empty() {
+		v = m.maskValue(v, nil)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (m *logMasker) maskValue(v interface{}, path []string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			p := append(path[:len(path):len(path)], strings.ToLower(k))
+			if m.match(p) {
+				val[k] = maskedValue
+			} else {
+				val[k] = m.maskValue(item, p)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = m.maskValue(item, path)
+		}
+	}
+	return v
+}
+
+// maskValues 脱敏表单或query参数，只按字段名匹配
+func (m *logMasker) maskValues(values url.Values) url.Values {
+	if m.empty() {
+		return values
+	}
+	masked := make(url.Values, len(values))
+	for k, vs := range values {
+		if m.match([]string{strings.ToLower(k)}) {
+			masked[k] = []string{maskedValue}
+		} else {
+			masked[k] = vs
+		}
+	}
+	return masked
+}
+
+func (m *logMasker) maskURI(uri string) string {
+	if m.empty() {
+		return uri
+	}
+	i := strings.IndexByte(uri, '?')
+	if i < 0 {
+		return uri
+	}
+	query, err := url.ParseQuery(uri[i+1:])
+	if err != nil {
+		return uri
+	}
+	return uri[:i+1] + m.maskValues(query).Encode()
+}
+
+func (m *logMasker) maskHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, vs := range h {
+		if _, ok := m.headers[k]; ok {
+			out[k] = maskedValue
+		} else {
+			out[k] = strings.Join(vs, ",")
+		}
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RollNA/harbour/routine"
+)
+
+const defaultMaxRequestCaptureSize = 10 << 20
+
+type payloadKind int
+
+const (
+	payloadJSON payloadKind = iota
+	payloadForm
+	payloadMultipart
+	payloadText
+	payloadBinary
+)
+
+func payloadKindOf(mediaType string) payloadKind {
+	switch {
+	// 没有Content-Type时按JSON尝试，失败再按二进制处理
+	case mediaType == "", mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return payloadJSON
+	case mediaType == "application/x-www-form-urlencoded":
+		return payloadForm
+	case mediaType == "multipart/form-data":
+		return payloadMultipart
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "xml"):
+		return payloadText
+	default:
+		return payloadBinary
+	}
+}
+
+// capturePayload 按Content-Type记录请求body，返回的函数在请求处理完后调用。
+// JSON规范化并脱敏，表单解析为字段并脱敏，multipart在业务读取时解析，只记录字段名、文件名和大小，
+// 文本无法按字段脱敏，配置了MaskFields时与二进制一样只记录sha256和长度；
+// 二进制和超过captureSize的body不会整体读入内存
+func capturePayload(r *http.Request, m *logMasker, captureSize int64, maxSize int) func() string {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return func() string { return "" }
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	kind := payloadKindOf(mediaType)
+
+	switch {
+	case kind == payloadBinary, kind == payloadText && !m.empty():
+		return teeHashPayload(r)
+	case kind == payloadMultipart:
+		return teeMultipartPayload(r, params["boundary"], maxSize)
+	case r.ContentLength > captureSize:
+		return teeHashPayload(r)
+	}
+	data, complete := readAhead(r, captureSize)
+	if !complete {
+		return teeHashPayload(r)
+	}
+
+	var payload string
+	switch kind {
+	case payloadJSON:
+		masked, ok := m.maskJSON(data)
+		if !ok {
+			return hashPayload(data)
+		}
+		payload = string(masked)
+	case payloadForm:
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return hashPayload(data)
+		}
+		payload = formPayload(m.maskValues(values))
+	case payloadText:
+		payload = string(data)
+	}
+	payload = truncate(payload, maxSize)
+	return func() string { return payload }
+}
+
+// readAhead 最多读取limit字节，超出时把已读部分放回body
+func readAhead(r *http.Request, limit int64) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(data)) > limit {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return data, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return data, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+// teeHashPayload 在业务读取body时计算sha256，只统计业务实际读取的部分
+func teeHashPayload(r *http.Request) func() string {
+	hr := &hashingReader{r: r.Body, h: sha256.New()}
+	r.Body = readCloser{hr, r.Body}
+	return func() string {
+		return digestPayload(hr.h, hr.n)
+	}
+}
+
+func hashPayload(data []byte) func() string {
+	h := sha256.New()
+	h.Write(data)
+	payload := digestPayload(h, int64(len(data)))
+	return func() string { return payload }
+}
+
+func digestPayload(h hash.Hash, n int64) string {
+	b, _ := json.Marshal(map[string]any{
+		"sha256": hex.EncodeToString(h.Sum(nil)),
+		"length": n,
+	})
+	return string(b)
+}
+
+func formPayload(values url.Values) string {
+	fields := make(map[string]any, len(values))
+	for k, vs := range values {
+		if len(vs) == 1 {
+			fields[k] = vs[0]
+		} else {
+			fields[k] = vs
+		}
+	}
+	b, _ := json.Marshal(fields)
+	return string(b)
+}
+
+// teeMultipartPayload 在业务读取body时解析multipart，只统计业务实际读取的部分，不缓存body
+func teeMultipartPayload(r *http.Request, boundary string, maxSize int) func() string {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	var payload string
+	routine.GoSafe(func() {
+		defer close(done)
+		payload = multipartPayload(pr, boundary)
+		// 解析结束后继续读取，业务读取body时不会阻塞
+		_, _ = io.Copy(io.Discard, pr)
+	})
+	r.Body = readCloser{io.TeeReader(r.Body, pw), r.Body}
+	return func() string {
+		_ = pw.Close()
+		<-done
+		return truncate(payload, maxSize)
+	}
+}
+
+func multipartPayload(body io.Reader, boundary string) string {
+	type file struct {
+		Field    string `json:"field"`
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+	fields := make(map[string]int64)
+	files := make([]file, 0)
+
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		n, _ := io.Copy(io.Discard, part)
+		if part.FileName() != "" {
+			files = append(files, file{Field: part.FormName(), Filename: part.FileName(), Size: n})
+		} else {
+			fields[part.FormName()] = n
+		}
+	}
+	b, _ := json.Marshal(map[string]any{"fields": fields, "files": files})
+	return string(b)
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapturePayloadText(t *testing.T) {
+	for _, contentType := range []string{"text/plain", "application/xml"} {
+		body := "password=hunter2 <password>hunter2</password>"
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		payload := capturePayload(r, newLogMasker([]string{"password"}, nil), defaultMaxRequestCaptureSize, 4096)
+
+		// 业务仍然读到完整的body
+		got, _ := io.ReadAll(r.Body)
+		assert.Equal(t, body, string(got), contentType)
+		assert.NotContains(t, payload(), "hunter2", contentType)
+		assert.Contains(t, payload(), `"length":45`, contentType)
+	}
+
+	// 没有配置MaskFields时原样记录
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	r.Header.Set("Content-Type", "text/plain")
+	assert.Equal(t, "hello", capturePayload(r, newLogMasker(nil, nil), defaultMaxRequestCaptureSize, 4096)())
+}
+
+func TestCapturePayloadMultipart(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("name", "alice")
+	fw, _ := mw.CreateFormFile("avatar", "a.png")
+	_, _ = fw.Write(bytes.Repeat([]byte{1}, 1<<20))
+	_ = mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(buf.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	// captureSize小于body时依然能解析，body不会被读入内存
+	payload := capturePayload(r, newLogMasker(nil, nil), 1024, 4096)
+
+	assert.NoError(t, r.ParseMultipartForm(1<<20))
+	assert.Equal(t, "alice", r.FormValue("name"))
+	assert.JSONEq(t, `{"fields":{"name":5},"files":[{"field":"avatar","filename":"a.png","size":1048576}]}`, payload())
+
+	// 业务没有读取body时不会阻塞
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(buf.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	assert.JSONEq(t, `{"fields":{},"files":[]}`, capturePayload(r, newLogMasker(nil, nil), 1024, 4096)())
+}
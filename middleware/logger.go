@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
-	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/RollNA/harbour/zLog"
@@ -15,23 +19,158 @@ import (
 )
 
 // 打印response，refer：https://stackoverflow.com/questions/38501325/how-to-log-response-body-in-gin
+// 只保留前limit字节，SSE等流式响应和skipTypes中的类型不缓存
 type bodyLogWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body      *bytes.Buffer
+	limit     int
+	skipTypes []string
+	checked   bool
+	skipped   bool
+	truncated bool
 }
 
 func (w *bodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+	w.capture(b)
 	return w.ResponseWriter.Write(b)
 }
 
 func (w *bodyLogWriter) WriteString(s string) (int, error) {
-	w.body.WriteString(s)
+	w.capture([]byte(s))
 	return w.ResponseWriter.WriteString(s)
 }
 
+// Flush 流式响应需要立即下发
+func (w *bodyLogWriter) Flush() {
+	w.ResponseWriter.Flush()
+}
+
+// Hijack 连接被接管后(如websocket)不再记录
+func (w *bodyLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.checked, w.skipped = true, true
+	return w.ResponseWriter.Hijack()
+}
+
+// CloseNotify 兼容long-polling等依赖连接关闭通知的场景
+func (w *bodyLogWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.CloseNotify()
+}
+
+// Unwrap 供http.ResponseController使用
+func (w *bodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *bodyLogWriter) capture(b []byte) {
+	if !w.checked {
+		w.checked = true
+		contentType := strings.ToLower(w.Header().Get("Content-Type"))
+		for _, t := range w.skipTypes {
+			if strings.HasPrefix(contentType, t) {
+				w.skipped = true
+				break
+			}
+		}
+	}
+	if w.skipped || len(b) == 0 {
+		return
+	}
+	room := w.limit - w.body.Len()
+	if len(b) > room {
+		b = b[:room]
+		w.truncated = true
+	}
+	w.body.Write(b)
+}
+
+const (
+	defaultMaxRequestBodySize  = 4096
+	defaultMaxResponseBodySize = 1024
+	// 用于脱敏的response最大缓存，超过时JSON无法完整解析，不再打印
+	defaultMaxResponseCaptureSize = 64 << 10
+
+	skipAccessLogKey = "harbour/skipAccessLog"
+)
+
+type LoggerConf struct {
+	// 不打印日志的路径，如健康检查
+	SkipPaths []string `mapstructure:"skipPaths"`
+	// 不打印日志的路由模板，匹配c.FullPath()，如"/users/:id"
+	SkipRoutes []string `mapstructure:"skipRoutes"`
+	// payload最多打印的字节数，默认4096
+	MaxRequestBodySize int `mapstructure:"maxRequestBodySize"`
+	// 读入内存用于解析的最大body，超过时只记录sha256和长度，默认10MB
+	MaxRequestCaptureSize int64 `mapstructure:"maxRequestCaptureSize"`
+	// response最多打印的字节数，默认1024
+	MaxResponseBodySize int `mapstructure:"maxResponseBodySize"`
+	// 配置了MaskFields时，JSON response最多缓存的字节数，默认64KB
+	MaxResponseCaptureSize int `mapstructure:"maxResponseCaptureSize"`
+	// 不记录response的Content-Type前缀，text/event-stream始终不记录
+	SkipResponseContentTypes []string `mapstructure:"skipResponseContentTypes"`
+	// 需要脱敏的字段，作用于JSON body、表单和query参数，如"password"、"user.token"；
+	// 配置后text和xml body无法按字段脱敏，只记录sha256和长度
+	MaskFields []string `mapstructure:"maskFields"`
+	// 是否打印请求header，Authorization、Cookie等默认脱敏
+	LogHeaders bool `mapstructure:"logHeaders"`
+	// 额外需要脱敏的header
+	MaskHeaders []string `mapstructure:"maskHeaders"`
+	// 成功请求(status<400)的采样率，在(0,1)之间时生效，其他值全部打印
+	SuccessSampleRate float64 `mapstructure:"successSampleRate"`
+}
+
+// SkipAccessLog 挂在单个路由或路由组上，跳过该路由的访问日志，也不会读取和缓存请求、响应body
+func SkipAccessLog(c *gin.Context) {
+	c.Set(skipAccessLogKey, true)
+	c.Next()
+}
+
+// skipAccessLogName 与c.HandlerNames()中的名字一致，日志中间件在路由的handler执行前据此判断
+var skipAccessLogName = runtime.FuncForPC(reflect.ValueOf(SkipAccessLog).Pointer()).Name()
+
+func routeSkipsAccessLog(c *gin.Context) bool {
+	for _, name := range c.HandlerNames() {
+		if name == skipAccessLogName {
+			return true
+		}
+	}
+	return false
+}
+
 // LoggerToFile 日志记录到文件
 func LoggerToFile() gin.HandlerFunc {
+	return LoggerToFileWithConf(LoggerConf{})
+}
+
+func LoggerToFileWithConf(conf LoggerConf) gin.HandlerFunc {
+	if conf.MaxRequestBodySize <= 0 {
+		conf.MaxRequestBodySize = defaultMaxRequestBodySize
+	}
+	if conf.MaxRequestCaptureSize <= 0 {
+		conf.MaxRequestCaptureSize = defaultMaxRequestCaptureSize
+	}
+	if conf.MaxResponseBodySize <= 0 {
+		conf.MaxResponseBodySize = defaultMaxResponseBodySize
+	}
+	if conf.MaxResponseCaptureSize <= 0 {
+		conf.MaxResponseCaptureSize = defaultMaxResponseCaptureSize
+	}
+	skipTypes := []string{"text/event-stream"}
+	for _, t := range conf.SkipResponseContentTypes {
+		skipTypes = append(skipTypes, strings.ToLower(t))
+	}
+	skipPaths := make(map[string]struct{}, len(conf.SkipPaths))
+	for _, path := range conf.SkipPaths {
+		skipPaths[path] = struct{}{}
+	}
+	skipRoutes := make(map[string]struct{}, len(conf.SkipRoutes))
+	for _, route := range conf.SkipRoutes {
+		skipRoutes[route] = struct{}{}
+	}
+	masker := newLogMasker(conf.MaskFields, conf.MaskHeaders)
+	captureSize := conf.MaxResponseBodySize
+	if !masker.empty() && conf.MaxResponseCaptureSize > captureSize {
+		captureSize = conf.MaxResponseCaptureSize
+	}
 
 	return func(c *gin.Context) {
 		// 开始时间
@@ -42,24 +181,39 @@ func LoggerToFile() gin.HandlerFunc {
 			c.Next()
 			return
 		}
+		if _, ok := skipPaths[c.Request.URL.Path]; ok {
+			c.Next()
+			return
+		}
+		if _, ok := skipRoutes[c.FullPath()]; ok || routeSkipsAccessLog(c) {
+			c.Next()
+			return
+		}
 
 		// payload
-		payload := ""
-		if c.Request.Method == http.MethodPost {
-			payloadBytes, _ := c.GetRawData()
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(payloadBytes))
-			var jsonData interface{}
-			_ = json.Unmarshal(payloadBytes, &jsonData)
-			marshalledBytes, _ := json.Marshal(jsonData)
-			payload = string(marshalledBytes)
-		}
+		payload := capturePayload(c.Request, masker, conf.MaxRequestCaptureSize, conf.MaxRequestBodySize)
 
-		blw := &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+		blw := &bodyLogWriter{
+			body:           bytes.NewBufferString(""),
+			ResponseWriter: c.Writer,
+			limit:          captureSize,
+			skipTypes:      skipTypes,
+		}
 		c.Writer = blw
 
 		// 处理请求
 		c.Next()
 
+		if c.GetBool(skipAccessLogKey) {
+			return
+		}
+		// 状态码
+		statusCode := c.Writer.Status()
+		if statusCode < http.StatusBadRequest && conf.SuccessSampleRate > 0 && conf.SuccessSampleRate < 1 &&
+			rand.Float64() >= conf.SuccessSampleRate {
+			return
+		}
+
 		// 结束时间
 		endTime := time.Now()
 		// 执行时间
@@ -67,9 +221,7 @@ func LoggerToFile() gin.HandlerFunc {
 		// 请求方式
 		reqMethod := c.Request.Method
 		// 请求路由
-		reqUri := c.Request.RequestURI
-		// 状态码
-		statusCode := c.Writer.Status()
+		reqUri := masker.maskURI(c.Request.RequestURI)
 		// 请求IP
 		clientIP := c.ClientIP()
 		// ua
@@ -77,26 +229,53 @@ func LoggerToFile() gin.HandlerFunc {
 		// body string
 
 		bodyString := blw.body.String()
+		contentType := blw.Header().Get("Content-Type")
 		encoding := blw.Header().Get("Content-Encoding")
-		if encoding == "gzip" {
+		switch {
+		case blw.skipped:
+			bodyString = "skipped " + contentType
+		case blw.body.Len() == 0:
+		case encoding == "gzip":
 			bodyString = "gziped body"
+		case isJSONContentType(contentType) && !masker.empty():
+			if masked, ok := masker.maskJSON(blw.body.Bytes()); ok && !blw.truncated {
+				bodyString = truncate(string(masked), conf.MaxResponseBodySize)
+			} else {
+				// 不完整的JSON无法脱敏
+				bodyString = "unmaskable body"
+			}
+		default:
+			bodyString = truncate(bodyString, conf.MaxResponseBodySize)
 		}
-		if len(bodyString) > 1024 {
-			bodyString = bodyString[0:1024]
-		}
-		// 日志格式
-		zLog.TraceInfo(
-			c.Request.Context(),
-			"gin request",
+
+		fields := []zap.Field{
 			zap.String("start", startTime.Format(time.RFC3339)),
 			zap.Any("statusCode", statusCode),
 			zap.Any("cost", latencyTime),
 			zap.String("clientIP", clientIP),
 			zap.String("method", reqMethod),
 			zap.String("uri", reqUri),
+			zap.String("route", c.FullPath()),
 			zap.String("ua", ua),
-			zap.String("payload", payload),
+			zap.String("payload", payload()),
 			zap.String("response", bodyString),
-		)
+			zap.Int("size", c.Writer.Size()),
+		}
+		if conf.LogHeaders {
+			fields = append(fields, zap.Any("headers", masker.maskHeaders(c.Request.Header)))
+		}
+		// 日志格式
+		zLog.TraceInfo(c.Request.Context(), "gin request", fields...)
+	}
+}
+
+func truncate(s string, size int) string {
+	if len(s) > size {
+		return s[0:size]
 	}
+	return s
+}
+
+func isJSONContentType(contentType string) bool {
+	return strings.Contains(strings.ToLower(contentType), "json")
 }
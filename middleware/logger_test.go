@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipAccessLogBeforeCapture(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(LoggerToFileWithConf(LoggerConf{}))
+
+	captured := map[string]bool{}
+	handler := func(c *gin.Context) {
+		_, captured[c.FullPath()] = c.Writer.(*bodyLogWriter)
+		c.Status(http.StatusOK)
+	}
+	r.POST("/skip", SkipAccessLog, handler)
+	r.Group("/group", SkipAccessLog).POST("/skip", handler)
+	r.POST("/log", handler)
+
+	for _, path := range []string{"/skip", "/group/skip", "/log"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"a":1}`)))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+	assert.False(t, captured["/skip"])
+	assert.False(t, captured["/group/skip"])
+	assert.True(t, captured["/log"])
+}
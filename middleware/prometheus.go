@@ -1,10 +1,8 @@
 package middleware
 
 import (
-	"bytes"
-	"io/ioutil"
+	"errors"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -41,22 +39,48 @@ var reqDur = &Metric{
 var resSz = &Metric{
 	ID:          "resSz",
 	Name:        "response_size_bytes",
-	Description: "The HTTP response sizes in bytes.",
-	Type:        "summary",
+	Description: "The HTTP response sizes in bytes, partitioned by url and HTTP method.",
+	Type:        "histogram_vec",
+	Args:        []string{"url", "method"},
+	Buckets:     defaultSizeBuckets,
 }
 
 var reqSz = &Metric{
 	ID:          "reqSz",
 	Name:        "request_size_bytes",
-	Description: "The HTTP request sizes in bytes.",
-	Type:        "summary",
+	Description: "The HTTP request sizes in bytes, partitioned by url and HTTP method.",
+	Type:        "histogram_vec",
+	Args:        []string{"url", "method"},
+	Buckets:     defaultSizeBuckets,
 }
 
+var reqInFlight = &Metric{
+	ID:          "reqInFlight",
+	Name:        "requests_in_flight",
+	Description: "How many HTTP requests are being processed, partitioned by url and HTTP method.",
+	Type:        "gauge_vec",
+	Args:        []string{"url", "method"},
+}
+
+var reqAborted = &Metric{
+	ID:          "reqAborted",
+	Name:        "requests_aborted_total",
+	Description: "How many HTTP requests were aborted by a middleware, partitioned by url and HTTP method.",
+	Type:        "counter_vec",
+	Args:        []string{"url", "method"},
+}
+
+// 256B ~ 16MB
+var defaultSizeBuckets = prometheus.ExponentialBuckets(256, 4, 9)
+
 var standardMetrics = []*Metric{
 	reqCnt,
 	reqDur,
 	resSz,
 	reqSz,
+	reqInFlight,
+	reqAborted,
+	urlLabelDropped,
 }
 
 /*
@@ -89,15 +113,35 @@ type Metric struct {
 	Description     string
 	Type            string
 	Args            []string
+
+	// ConstLabels are attached to every series of the metric
+	ConstLabels prometheus.Labels
+	// Buckets for histogram and histogram_vec, prometheus.DefBuckets if empty
+	Buckets []float64
+	// Objectives for summary and summary_vec, no quantiles if empty
+	Objectives map[float64]float64
+	// NativeHistogramBucketFactor enables native histograms when greater than 1
+	NativeHistogramBucketFactor     float64
+	NativeHistogramZeroThreshold    float64
+	NativeHistogramMaxBucketNumber  uint32
+	NativeHistogramMinResetDuration time.Duration
 }
 
 // Prometheus contains the metrics gathered by the instance and its path
 type Prometheus struct {
 	reqCnt        *prometheus.CounterVec
 	reqDur        *prometheus.HistogramVec
-	reqSz, resSz  prometheus.Summary
+	reqSz, resSz  *prometheus.HistogramVec
+	reqInFlight   *prometheus.GaugeVec
+	reqAborted    *prometheus.CounterVec
+	urlDropped    prometheus.Counter
+	urlLimiter    *urlLabelLimiter
 	router        *gin.Engine
 	listenAddress string
+	subsystem     string
+	conf          PrometheusConf
+	registerer    prometheus.Registerer
+	gatherer      prometheus.Gatherer
 	Ppg           PrometheusPushGateway
 
 	MetricsList []*Metric
@@ -105,33 +149,55 @@ type Prometheus struct {
 
 	ReqCntURLLabelMappingFn RequestCounterURLLabelMappingFn
 
-	// gin.Context string to use as a prometheus URL label
+	// gin.Context string to use as a prometheus URL label,
+	// 需要在Prometheus中间件之前的中间件中写入，每个请求的url label只计算一次
 	URLLabelFromContext string
 }
 
-// PrometheusPushGateway contains the configuration for pushing to a Prometheus pushgateway (optional)
-type PrometheusPushGateway struct {
-	// Push interval in seconds
-	PushIntervalSeconds time.Duration
-
-	// Push Gateway URL in format http://domain:port
-	// where JOBNAME can be any string of your choice
-	PushGatewayURL string
-
-	// Local metrics URL where metrics are fetched from, this could be ommited in the future
-	// if implemented using prometheus common/expfmt instead
-	MetricsURL string
-
-	// pushgateway job name, defaults to "gin"
-	Job string
+// PrometheusConf configures the metrics created by NewPrometheusWithConf
+type PrometheusConf struct {
+	Subsystem string `mapstructure:"subsystem"`
+	// 默认/metrics
+	MetricsPath string `mapstructure:"metricsPath"`
+	// 设置后在单独的地址暴露metrics，不占用业务路由
+	ListenAddress string `mapstructure:"listenAddress"`
+	// 设置后metrics接口需要BasicAuth
+	Accounts gin.Accounts `mapstructure:"accounts"`
+
+	// request_duration_seconds的bucket，为空时使用prometheus.DefBuckets
+	DurationBuckets []float64 `mapstructure:"durationBuckets"`
+	// 大于1时request_duration_seconds同时输出native histogram
+	NativeHistogramBucketFactor    float64 `mapstructure:"nativeHistogramBucketFactor"`
+	NativeHistogramMaxBucketNumber uint32  `mapstructure:"nativeHistogramMaxBucketNumber"`
+	// request_size_bytes和response_size_bytes的bucket，默认256B到16MB
+	SizeBuckets []float64 `mapstructure:"sizeBuckets"`
+	// 附加到所有标准指标上的固定label
+	ConstLabels map[string]string `mapstructure:"constLabels"`
+	// requests_total和request_duration_seconds不带handler label，
+	// c.HandlerName()是完整的函数名，较长且路由多时基数很高
+	DisableHandlerLabel bool `mapstructure:"disableHandlerLabel"`
+	// url label不同取值的上限，超出后新出现的值记为other，<=0时不限制。
+	// 使用自定义ReqCntURLLabelMappingFn或URLLabelFromContext时建议设置
+	MaxURLLabelValues int `mapstructure:"maxURLLabelValues"`
+
+	// 注册指标使用的registry，默认prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer `mapstructure:"-"`
+	// metrics接口和pushgateway使用的registry，默认与Registerer相同(需为*prometheus.Registry)，
+	// 否则为prometheus.DefaultGatherer
+	Gatherer prometheus.Gatherer `mapstructure:"-"`
 }
 
 // NewPrometheus generates a new set of metrics with a certain subsystem name
 func NewPrometheus(subsystem string, customMetricsList ...[]*Metric) *Prometheus {
+	return NewPrometheusWithConf(PrometheusConf{Subsystem: subsystem}, customMetricsList...)
+}
+
+// NewPrometheusWithConf generates a new set of metrics, the standard metrics are tuned by conf
+func NewPrometheusWithConf(conf PrometheusConf, customMetricsList ...[]*Metric) *Prometheus {
 	var metricsList []*Metric
 
 	if len(customMetricsList) > 1 {
-		panic("Too many args. NewPrometheus( string, <optional []*Metric> ).")
+		panic("Too many args. NewPrometheusWithConf( PrometheusConf, <optional []*Metric> ).")
 	} else if len(customMetricsList) == 1 {
 		metricsList = customMetricsList[0]
 	}
@@ -146,25 +212,60 @@ func NewPrometheus(subsystem string, customMetricsList ...[]*Metric) *Prometheus
 		ReqCntURLLabelMappingFn: func(c *gin.Context) string {
 			return c.FullPath()
 		},
+		conf:       conf,
+		urlLimiter: newURLLabelLimiter(conf.MaxURLLabelValues),
+		registerer: conf.Registerer,
+		gatherer:   conf.Gatherer,
+	}
+	if p.registerer == nil {
+		p.registerer = prometheus.DefaultRegisterer
+	}
+	if p.gatherer == nil {
+		if g, ok := p.registerer.(prometheus.Gatherer); ok {
+			p.gatherer = g
+		} else {
+			p.gatherer = prometheus.DefaultGatherer
+		}
+	}
+	if conf.MetricsPath != "" {
+		p.MetricsPath = conf.MetricsPath
 	}
 
-	p.registerMetrics(subsystem)
+	p.registerMetrics(conf.Subsystem)
 
 	return p
 }
 
-// SetPushGateway sends metrics to a remote pushgateway exposed on pushGatewayURL
-// every pushIntervalSeconds. Metrics are fetched from metricsURL
-func (p *Prometheus) SetPushGateway(pushGatewayURL, metricsURL string, pushIntervalSeconds time.Duration) {
-	p.Ppg.PushGatewayURL = pushGatewayURL
-	p.Ppg.MetricsURL = metricsURL
-	p.Ppg.PushIntervalSeconds = pushIntervalSeconds
-	p.startPushTicker()
-}
-
-// SetPushGatewayJob job name, defaults to "gin"
-func (p *Prometheus) SetPushGatewayJob(j string) {
-	p.Ppg.Job = j
+// tune applies conf to a copy of a standard metric definition
+func (p *Prometheus) tune(metricDef *Metric) *Metric {
+	isStandard := false
+	for _, m := range standardMetrics {
+		if m == metricDef {
+			isStandard = true
+			break
+		}
+	}
+	if !isStandard {
+		return metricDef
+	}
+	def := *metricDef
+	if len(p.conf.ConstLabels) > 0 {
+		def.ConstLabels = p.conf.ConstLabels
+	}
+	switch metricDef {
+	case reqDur:
+		def.Buckets = p.conf.DurationBuckets
+		def.NativeHistogramBucketFactor = p.conf.NativeHistogramBucketFactor
+		def.NativeHistogramMaxBucketNumber = p.conf.NativeHistogramMaxBucketNumber
+	case reqSz, resSz:
+		if len(p.conf.SizeBuckets) > 0 {
+			def.Buckets = p.conf.SizeBuckets
+		}
+	}
+	if p.conf.DisableHandlerLabel && (metricDef == reqCnt || metricDef == reqDur) {
+		def.Args = []string{"code", "url", "host", "method"}
+	}
+	return &def
 }
 
 // SetListenAddress for exposing metrics on address. If not set, it will be exposed at the
@@ -188,20 +289,20 @@ func (p *Prometheus) SetListenAddressWithRouter(listenAddress string, r *gin.Eng
 // SetMetricsPath set metrics paths
 func (p *Prometheus) SetMetricsPath(e *gin.Engine) {
 	if p.listenAddress != "" {
-		p.router.GET(p.MetricsPath, prometheusHandler())
+		p.router.GET(p.MetricsPath, p.metricsHandler())
 		p.runServer()
 	} else {
-		e.GET(p.MetricsPath, prometheusHandler())
+		e.GET(p.MetricsPath, p.metricsHandler())
 	}
 }
 
 // SetMetricsPathWithAuth set metrics paths with authentication
 func (p *Prometheus) SetMetricsPathWithAuth(e *gin.Engine, accounts gin.Accounts) {
 	if p.listenAddress != "" {
-		p.router.GET(p.MetricsPath, gin.BasicAuth(accounts), prometheusHandler())
+		p.router.GET(p.MetricsPath, gin.BasicAuth(accounts), p.metricsHandler())
 		p.runServer()
 	} else {
-		e.GET(p.MetricsPath, gin.BasicAuth(accounts), prometheusHandler())
+		e.GET(p.MetricsPath, gin.BasicAuth(accounts), p.metricsHandler())
 	}
 }
 
@@ -211,131 +312,92 @@ func (p *Prometheus) runServer() {
 	}
 }
 
-func (p *Prometheus) getMetrics() []byte {
-	response, _ := http.Get(p.Ppg.MetricsURL)
-
-	defer response.Body.Close()
-	body, _ := ioutil.ReadAll(response.Body)
-
-	return body
-}
-
-func (p *Prometheus) getPushGatewayURL() string {
-	h, _ := os.Hostname()
-	if p.Ppg.Job == "" {
-		p.Ppg.Job = "gin"
-	}
-	return p.Ppg.PushGatewayURL + "/metrics/job/" + p.Ppg.Job + "/instance/" + h
-}
-
-func (p *Prometheus) sendMetricsToPushGateway(metrics []byte) {
-	req, err := http.NewRequest("POST", p.getPushGatewayURL(), bytes.NewBuffer(metrics))
-	client := &http.Client{}
-	if _, err = client.Do(req); err != nil {
-		zLog.Error("Error sending to push gateway. ", zap.Error(err))
-	}
-}
-
-func (p *Prometheus) startPushTicker() {
-	ticker := time.NewTicker(time.Second * p.Ppg.PushIntervalSeconds)
-	go func() {
-		for range ticker.C {
-			p.sendMetricsToPushGateway(p.getMetrics())
-		}
-	}()
-}
-
 // NewMetric associates prometheus.Collector based on Metric.Type
 func NewMetric(m *Metric, subsystem string) prometheus.Collector {
 	var metric prometheus.Collector
+	counterOpts := prometheus.CounterOpts{
+		Subsystem:   subsystem,
+		Name:        m.Name,
+		Help:        m.Description,
+		ConstLabels: m.ConstLabels,
+	}
+	gaugeOpts := prometheus.GaugeOpts{
+		Subsystem:   subsystem,
+		Name:        m.Name,
+		Help:        m.Description,
+		ConstLabels: m.ConstLabels,
+	}
+	histogramOpts := prometheus.HistogramOpts{
+		Subsystem:                       subsystem,
+		Name:                            m.Name,
+		Help:                            m.Description,
+		ConstLabels:                     m.ConstLabels,
+		Buckets:                         m.Buckets,
+		NativeHistogramBucketFactor:     m.NativeHistogramBucketFactor,
+		NativeHistogramZeroThreshold:    m.NativeHistogramZeroThreshold,
+		NativeHistogramMaxBucketNumber:  m.NativeHistogramMaxBucketNumber,
+		NativeHistogramMinResetDuration: m.NativeHistogramMinResetDuration,
+	}
+	summaryOpts := prometheus.SummaryOpts{
+		Subsystem:   subsystem,
+		Name:        m.Name,
+		Help:        m.Description,
+		ConstLabels: m.ConstLabels,
+		Objectives:  m.Objectives,
+	}
 	switch m.Type {
 	case "counter_vec":
-		metric = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-			m.Args,
-		)
+		metric = prometheus.NewCounterVec(counterOpts, m.Args)
 	case "counter":
-		metric = prometheus.NewCounter(
-			prometheus.CounterOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-		)
+		metric = prometheus.NewCounter(counterOpts)
 	case "gauge_vec":
-		metric = prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-			m.Args,
-		)
+		metric = prometheus.NewGaugeVec(gaugeOpts, m.Args)
 	case "gauge":
-		metric = prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-		)
+		metric = prometheus.NewGauge(gaugeOpts)
 	case "histogram_vec":
-		metric = prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-			m.Args,
-		)
+		metric = prometheus.NewHistogramVec(histogramOpts, m.Args)
 	case "histogram":
-		metric = prometheus.NewHistogram(
-			prometheus.HistogramOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-		)
+		metric = prometheus.NewHistogram(histogramOpts)
 	case "summary_vec":
-		metric = prometheus.NewSummaryVec(
-			prometheus.SummaryOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-			m.Args,
-		)
+		metric = prometheus.NewSummaryVec(summaryOpts, m.Args)
 	case "summary":
-		metric = prometheus.NewSummary(
-			prometheus.SummaryOpts{
-				Subsystem: subsystem,
-				Name:      m.Name,
-				Help:      m.Description,
-			},
-		)
+		metric = prometheus.NewSummary(summaryOpts)
+	}
+	return metric
+}
+
+func (p *Prometheus) registerMetric(metricDef *Metric) prometheus.Collector {
+	metric := NewMetric(p.tune(metricDef), p.subsystem)
+	if err := p.registerer.Register(metric); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			// 同一个registry上多次NewPrometheus时复用已注册的collector
+			return are.ExistingCollector
+		}
+		zLog.Error("could not be registered in Prometheus", zap.String("metricName", metricDef.Name), zap.Error(err))
 	}
 	return metric
 }
 
 func (p *Prometheus) registerMetrics(subsystem string) {
+	p.subsystem = subsystem
 	for _, metricDef := range p.MetricsList {
-		metric := NewMetric(metricDef, subsystem)
-		if err := prometheus.Register(metric); err != nil {
-			zLog.Error("could not be registered in Prometheus", zap.String("metricName", metricDef.Name))
-		}
+		metric := p.registerMetric(metricDef)
 		switch metricDef {
 		case reqCnt:
 			p.reqCnt = metric.(*prometheus.CounterVec)
 		case reqDur:
 			p.reqDur = metric.(*prometheus.HistogramVec)
 		case resSz:
-			p.resSz = metric.(prometheus.Summary)
+			p.resSz = metric.(*prometheus.HistogramVec)
 		case reqSz:
-			p.reqSz = metric.(prometheus.Summary)
+			p.reqSz = metric.(*prometheus.HistogramVec)
+		case reqInFlight:
+			p.reqInFlight = metric.(*prometheus.GaugeVec)
+		case reqAborted:
+			p.reqAborted = metric.(*prometheus.CounterVec)
+		case urlLabelDropped:
+			p.urlDropped = metric.(prometheus.Counter)
 		}
 		metricDef.MetricCollector = metric
 	}
@@ -363,6 +425,13 @@ func (p *Prometheus) HandlerFunc() gin.HandlerFunc {
 
 		start := time.Now()
 		reqSz := computeApproximateRequestSize(c.Request)
+		method := c.Request.Method
+
+		// 路由在进入中间件前已匹配，in-flight与其他指标使用同一个url label
+		url := p.urlLabel(c)
+		inFlight := p.reqInFlight.WithLabelValues(url, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
 
 		c.Next()
 
@@ -370,26 +439,74 @@ func (p *Prometheus) HandlerFunc() gin.HandlerFunc {
 		elapsed := float64(time.Since(start)) / float64(time.Second)
 		resSz := float64(c.Writer.Size())
 
-		url := p.ReqCntURLLabelMappingFn(c)
-		// jlambert Oct 2018 - sidecar specific mod
-		if len(p.URLLabelFromContext) > 0 {
-			u, found := c.Get(p.URLLabelFromContext)
-			if !found {
-				u = "unknown"
-			}
-			url = u.(string)
+		labels := []string{status, url, c.HandlerName(), c.Request.Host, method}
+		if p.conf.DisableHandlerLabel {
+			labels = []string{status, url, c.Request.Host, method}
+		}
+		observeWithTraceExemplar(c, p.reqDur.WithLabelValues(labels...), elapsed)
+		p.reqCnt.WithLabelValues(labels...).Inc()
+		p.reqSz.WithLabelValues(url, method).Observe(float64(reqSz))
+		// Size为-1表示没有写入body
+		p.resSz.WithLabelValues(url, method).Observe(max(resSz, 0))
+
+		// panic由Recovery计入panics_total，这里只统计普通abort
+		if _, ok := c.Get(panicRecoveredKey); !ok && c.IsAborted() {
+			p.reqAborted.WithLabelValues(url, method).Inc()
+		}
+	}
+}
+
+// cachedURLLabel 同一个请求中HandlerFunc和Recovery共用的url label
+type cachedURLLabel struct {
+	p     *Prometheus
+	label string
+}
+
+const urlLabelKey = "harbour/middleware/prometheusURLLabel"
+
+// urlLabel 计算url label，并限制不同取值的数量。结果缓存在gin.Context中，
+// 同一个请求只占用一次取值上限，超出上限时url_label_dropped_total也只计一次
+func (p *Prometheus) urlLabel(c *gin.Context) string {
+	if v, ok := c.Get(urlLabelKey); ok {
+		if cached, ok := v.(cachedURLLabel); ok && cached.p == p {
+			return cached.label
 		}
-		p.reqDur.WithLabelValues(status, url, c.HandlerName(), c.Request.Host,
-			c.Request.Method).Observe(elapsed)
-		p.reqCnt.WithLabelValues(status, url, c.HandlerName(), c.Request.Host,
-			c.Request.Method).Inc()
-		p.reqSz.Observe(float64(reqSz))
-		p.resSz.Observe(resSz)
 	}
+	url := p.ReqCntURLLabelMappingFn(c)
+	// jlambert Oct 2018 - sidecar specific mod
+	if len(p.URLLabelFromContext) > 0 {
+		u, _ := c.Get(p.URLLabelFromContext)
+		url = urlLabelFromValue(u)
+	}
+	url, dropped := p.urlLimiter.label(url)
+	if dropped {
+		p.urlDropped.Inc()
+	}
+	c.Set(urlLabelKey, cachedURLLabel{p: p, label: url})
+	return url
+}
+
+// observeWithTraceExemplar attaches the sampled trace id as an exemplar,
+// exemplars are only exposed in the OpenMetrics format
+func observeWithTraceExemplar(c *gin.Context, o prometheus.Observer, v float64) {
+	sc := spanContextFromGin(c)
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && sc.IsSampled() {
+		eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+	o.Observe(v)
+}
+
+// Gatherer returns the registry the metrics are exposed from
+func (p *Prometheus) Gatherer() prometheus.Gatherer {
+	return p.gatherer
 }
 
-func prometheusHandler() gin.HandlerFunc {
-	h := promhttp.Handler()
+func (p *Prometheus) metricsHandler() gin.HandlerFunc {
+	h := promhttp.InstrumentMetricHandler(
+		p.registerer,
+		promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
 	}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// 未匹配到路由时c.FullPath()为空，统一归到这个label
+	unmatchedURLLabel = "<unmatched>"
+	// 不同url label数超过上限后新出现的值归到这个label
+	overflowURLLabel = "other"
+	// URLLabelFromContext指定的key不存在时使用
+	unknownURLLabel = "unknown"
+)
+
+var urlLabelDropped = &Metric{
+	ID:          "urlLabelDropped",
+	Name:        "url_label_dropped_total",
+	Description: "How many url label values were replaced by \"other\" because the distinct value limit was reached.",
+	Type:        "counter",
+}
+
+// urlLabelLimiter 限制url label的不同取值数量，max<=0时不限制
+type urlLabelLimiter struct {
+	max  int
+	mu   sync.RWMutex
+	seen map[string]struct{}
+}
+
+func newURLLabelLimiter(max int) *urlLabelLimiter {
+	return &urlLabelLimiter{max: max, seen: make(map[string]struct{})}
+}
+
+// label 返回实际使用的label值，超过上限的新值返回other和true
+func (l *urlLabelLimiter) label(v string) (string, bool) {
+	if v == "" {
+		return unmatchedURLLabel, false
+	}
+	if l.max <= 0 {
+		return v, false
+	}
+	l.mu.RLock()
+	_, ok := l.seen[v]
+	l.mu.RUnlock()
+	if ok {
+		return v, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v, false
+	}
+	if len(l.seen) >= l.max {
+		return overflowURLLabel, true
+	}
+	l.seen[v] = struct{}{}
+	return v, false
+}
+
+// urlLabelFromValue 把gin.Context中的值转为label，不是字符串时不会panic
+func urlLabelFromValue(v any) string {
+	switch u := v.(type) {
+	case nil:
+		return unknownURLLabel
+	case string:
+		return u
+	case []byte:
+		return string(u)
+	case fmt.Stringer:
+		return u.String()
+	default:
+		return fmt.Sprint(u)
+	}
+}
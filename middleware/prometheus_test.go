@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// gatheredURLs 返回指标各个样本的url label
+func gatheredURLs(t *testing.T, reg *prometheus.Registry, name string) []string {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	var urls []string
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "url" {
+					urls = append(urls, l.GetValue())
+				}
+			}
+		}
+	}
+	return urls
+}
+
+func TestURLLabelOncePerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithConf(PrometheusConf{Registerer: reg, MaxURLLabelValues: 1})
+
+	r := gin.New()
+	r.Use(p.HandlerFunc(), Recovery(RecoveryConf{Prometheus: p}))
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	for _, path := range []string{"/ok", "/panic"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// 超出上限的路由panic时只计一次
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	dropped := 0.0
+	for _, f := range families {
+		if f.GetName() == "url_label_dropped_total" {
+			dropped = f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, 1.0, dropped)
+	assert.ElementsMatch(t, []string{overflowURLLabel}, gatheredURLs(t, reg, "panics_total"))
+	// in-flight与其他指标使用同一组label，不额外占用上限
+	assert.ElementsMatch(t, []string{"/ok", overflowURLLabel}, gatheredURLs(t, reg, "requests_in_flight"))
+	assert.ElementsMatch(t, []string{"/ok", overflowURLLabel}, gatheredURLs(t, reg, "requests_total"))
+}
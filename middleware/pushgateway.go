@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RollNA/harbour/retry"
+	"github.com/RollNA/harbour/routine"
+	"github.com/RollNA/harbour/zLog"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPushJob           = "gin"
+	defaultPushInterval      = 15 * time.Second
+	defaultPushRetryAttempts = 3
+	defaultPushRetryDelay    = 500 * time.Millisecond
+	defaultPushTimeout       = 10 * time.Second
+)
+
+// PrometheusPushGateway contains the configuration for pushing to a Prometheus pushgateway (optional)
+//
+// Deprecated: use PushGatewayConf and Prometheus.StartPushGateway
+type PrometheusPushGateway struct {
+	// Push interval in seconds
+	PushIntervalSeconds time.Duration
+
+	// Push Gateway URL in format http://domain:port
+	PushGatewayURL string
+
+	// Deprecated: metrics are gathered from the registry, no longer fetched from this URL
+	MetricsURL string
+
+	// pushgateway job name, defaults to "gin"
+	Job string
+}
+
+type PushGatewayConf struct {
+	// pushgateway地址，如http://127.0.0.1:9091
+	URL string `mapstructure:"url"`
+	// 默认gin
+	Job string `mapstructure:"job"`
+	// 推送间隔，默认15s
+	Interval time.Duration `mapstructure:"interval"`
+	// 分组label，为空时使用instance=hostname
+	Grouping map[string]string `mapstructure:"grouping"`
+	// 设置后使用BasicAuth
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// 每次推送的最大尝试次数，默认3
+	RetryAttempts uint `mapstructure:"retryAttempts"`
+	// 重试间隔，默认500ms，按指数退避
+	RetryDelay time.Duration `mapstructure:"retryDelay"`
+	// 为true时使用PUT替换整个分组，默认POST只替换同名指标
+	Replace bool `mapstructure:"replace"`
+	// 停止时从pushgateway删除该分组，避免实例下线后指标一直残留
+	DeleteOnStop bool `mapstructure:"deleteOnStop"`
+
+	// 为空时使用10s超时的client，stop时的删除没有ctx，依赖超时避免一直阻塞
+	HTTPClient *http.Client `mapstructure:"-"`
+}
+
+// SetPushGateway sends metrics to a remote pushgateway exposed on pushGatewayURL
+// every pushIntervalSeconds. metricsURL is ignored, metrics are gathered from the registry.
+//
+// Deprecated: use StartPushGateway, which supports grouping, auth and stopping
+func (p *Prometheus) SetPushGateway(pushGatewayURL, metricsURL string, pushIntervalSeconds time.Duration) {
+	p.Ppg.PushGatewayURL = pushGatewayURL
+	p.Ppg.MetricsURL = metricsURL
+	p.Ppg.PushIntervalSeconds = pushIntervalSeconds
+
+	interval := pushIntervalSeconds
+	// 兼容传入秒数的旧用法，如SetPushGateway(url, "", 15)
+	if interval < time.Second {
+		interval *= time.Second
+	}
+	_, err := p.StartPushGateway(context.Background(), PushGatewayConf{
+		URL:      pushGatewayURL,
+		Job:      p.Ppg.Job,
+		Interval: interval,
+	})
+	if err != nil {
+		zLog.Error("Error starting push gateway. ", zap.Error(err))
+	}
+}
+
+// SetPushGatewayJob job name, defaults to "gin"
+//
+// Deprecated: use PushGatewayConf.Job
+func (p *Prometheus) SetPushGatewayJob(j string) {
+	p.Ppg.Job = j
+}
+
+// StartPushGateway 按Interval把registry中的指标以text格式推送到pushgateway，
+// ctx结束或调用返回的stop后停止，stop会等待正在进行的推送完成
+func (p *Prometheus) StartPushGateway(ctx context.Context, conf PushGatewayConf) (stop func(), err error) {
+	if conf.URL == "" {
+		return nil, errors.New("pushgateway url is empty")
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = defaultPushInterval
+	}
+	pusher := p.newPusher(conf)
+	if err = pusher.Error(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	routine.GoSafe(func() {
+		defer close(done)
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if conf.DeleteOnStop {
+					if err := pusher.Delete(); err != nil {
+						zLog.Warn("Error deleting from push gateway. ", zap.Error(err))
+					}
+				}
+				return
+			case <-ticker.C:
+				if err := pushWithRetry(ctx, pusher, conf); err != nil && ctx.Err() == nil {
+					zLog.Error("Error sending to push gateway. ", zap.Error(err))
+				}
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}, nil
+}
+
+// PushOnce 立即推送一次，可用于批处理任务结束前
+func (p *Prometheus) PushOnce(ctx context.Context, conf PushGatewayConf) error {
+	if conf.URL == "" {
+		return errors.New("pushgateway url is empty")
+	}
+	pusher := p.newPusher(conf)
+	if err := pusher.Error(); err != nil {
+		return err
+	}
+	return pushWithRetry(ctx, pusher, conf)
+}
+
+func (p *Prometheus) newPusher(conf PushGatewayConf) *push.Pusher {
+	if conf.Job == "" {
+		conf.Job = defaultPushJob
+	}
+	pusher := push.New(conf.URL, conf.Job).
+		Gatherer(p.gatherer).
+		Format(expfmt.NewFormat(expfmt.TypeTextPlain))
+	if len(conf.Grouping) == 0 {
+		h, _ := os.Hostname()
+		pusher.Grouping("instance", h)
+	}
+	for k, v := range conf.Grouping {
+		pusher.Grouping(k, v)
+	}
+	if conf.Username != "" {
+		pusher.BasicAuth(conf.Username, conf.Password)
+	}
+	if conf.HTTPClient == nil {
+		conf.HTTPClient = &http.Client{Timeout: defaultPushTimeout}
+	}
+	pusher.Client(conf.HTTPClient)
+	return pusher
+}
+
+func pushWithRetry(ctx context.Context, pusher *push.Pusher, conf PushGatewayConf) error {
+	if conf.RetryAttempts == 0 {
+		conf.RetryAttempts = defaultPushRetryAttempts
+	}
+	if conf.RetryDelay <= 0 {
+		conf.RetryDelay = defaultPushRetryDelay
+	}
+	return retry.Do(
+		func() error {
+			if conf.Replace {
+				return pusher.PushContext(ctx)
+			}
+			return pusher.AddContext(ctx)
+		},
+		retry.Context(ctx),
+		retry.Attempts(conf.RetryAttempts),
+		retry.Delay(conf.RetryDelay),
+		retry.LastErrorOnly(true),
+	)
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type pushRequest struct {
+	method, path, user, pass, contentType, body string
+}
+
+type testPushGateway struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []pushRequest
+	failures int32
+}
+
+// newTestPushGateway 前failures次请求返回500
+func newTestPushGateway(t *testing.T, failures int32) *testPushGateway {
+	gw := &testPushGateway{failures: failures}
+	gw.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		user, pass, _ := r.BasicAuth()
+		gw.mu.Lock()
+		gw.requests = append(gw.requests, pushRequest{
+			method:      r.Method,
+			path:        r.URL.Path,
+			user:        user,
+			pass:        pass,
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		})
+		gw.mu.Unlock()
+		if atomic.AddInt32(&gw.failures, -1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// 与真实的pushgateway一致，DELETE返回202
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(gw.Close)
+	return gw
+}
+
+func (gw *testPushGateway) snapshot() []pushRequest {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return append([]pushRequest(nil), gw.requests...)
+}
+
+// pushGrouping 把/metrics/job/<job>/<name>/<value>...解析为label
+func pushGrouping(t *testing.T, path string) map[string]string {
+	parts := strings.Split(strings.TrimPrefix(path, "/metrics/"), "/")
+	assert.Zero(t, len(parts)%2, path)
+	labels := make(map[string]string, len(parts)/2)
+	for i := 0; i+1 < len(parts); i += 2 {
+		labels[parts[i]] = parts[i+1]
+	}
+	return labels
+}
+
+func newTestPushPrometheus() *Prometheus {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithConf(PrometheusConf{Subsystem: "push", Registerer: reg})
+	p.reqCnt.WithLabelValues("200", "/ping", "main.ping", "localhost", "GET").Inc()
+	return p
+}
+
+func TestPushOnce(t *testing.T) {
+	gw := newTestPushGateway(t, 2)
+	p := newTestPushPrometheus()
+
+	err := p.PushOnce(context.Background(), PushGatewayConf{
+		URL:        gw.URL,
+		Job:        "batch",
+		Grouping:   map[string]string{"instance": "node-1", "zone": "a"},
+		Username:   "admin",
+		Password:   "secret",
+		RetryDelay: time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	reqs := gw.snapshot()
+	// 前两次失败后重试成功
+	assert.Len(t, reqs, 3)
+	last := reqs[2]
+	assert.Equal(t, http.MethodPost, last.method)
+	// push.Pusher按map遍历拼接分组label，顺序不固定
+	assert.Equal(t, map[string]string{"job": "batch", "instance": "node-1", "zone": "a"}, pushGrouping(t, last.path))
+	assert.Equal(t, "admin", last.user)
+	assert.Equal(t, "secret", last.pass)
+	assert.True(t, strings.HasPrefix(last.contentType, "text/plain"))
+	assert.Contains(t, last.body, "push_requests_total")
+}
+
+func TestPushOnceRetryExhausted(t *testing.T) {
+	gw := newTestPushGateway(t, 10)
+	p := newTestPushPrometheus()
+
+	err := p.PushOnce(context.Background(), PushGatewayConf{
+		URL:           gw.URL,
+		RetryAttempts: 2,
+		RetryDelay:    time.Millisecond,
+	})
+	assert.Error(t, err)
+	assert.Len(t, gw.snapshot(), 2)
+}
+
+func TestStartPushGateway(t *testing.T) {
+	gw := newTestPushGateway(t, 0)
+	p := newTestPushPrometheus()
+
+	stop, err := p.StartPushGateway(context.Background(), PushGatewayConf{
+		URL:          gw.URL,
+		Grouping:     map[string]string{"instance": "node-1"},
+		Interval:     10 * time.Millisecond,
+		Replace:      true,
+		DeleteOnStop: true,
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(gw.snapshot()) >= 2 }, time.Second, 5*time.Millisecond)
+	stop()
+	stop()
+
+	reqs := gw.snapshot()
+	assert.Equal(t, http.MethodPut, reqs[0].method)
+	assert.Equal(t, "/metrics/job/gin/instance/node-1", reqs[0].path)
+	assert.Equal(t, http.MethodDelete, reqs[len(reqs)-1].method)
+
+	// stop返回后不再推送
+	n := len(reqs)
+	time.Sleep(30 * time.Millisecond)
+	assert.Len(t, gw.snapshot(), n)
+}
+
+func TestStartPushGatewayRequiresURL(t *testing.T) {
+	p := newTestPushPrometheus()
+	_, err := p.StartPushGateway(context.Background(), PushGatewayConf{})
+	assert.Error(t, err)
+}
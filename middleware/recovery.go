@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/RollNA/harbour/zLog"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var panicCnt = &Metric{
+	ID:          "panicCnt",
+	Name:        "panics_total",
+	Description: "How many panics were recovered, partitioned by url and HTTP method.",
+	Type:        "counter_vec",
+	Args:        []string{"url", "method"},
+}
+
+// panicRecoveredKey Recovery捕获panic后写入gin.Context，外层的Prometheus中间件据此不把panic计为普通abort
+const panicRecoveredKey = "harbour/middleware/panicRecovered"
+
+// RecoveryResponseFunc 自定义panic后的响应
+type RecoveryResponseFunc func(c *gin.Context, recovered any)
+
+// RecoveryCallback panic后的回调，可用于告警
+type RecoveryCallback func(c *gin.Context, recovered any, stack []byte)
+
+type RecoveryConf struct {
+	// 为空时返回{"code":500,"message":"Internal Server Error","success":false}
+	Response RecoveryResponseFunc `mapstructure:"-"`
+	OnPanic  RecoveryCallback     `mapstructure:"-"`
+	// 设置后上报panic次数(panics_total)，是panic次数唯一的指标
+	Prometheus *Prometheus `mapstructure:"-"`
+}
+
+func defaultRecoveryResponse(c *gin.Context, _ any) {
+	c.AbortWithStatusJSON(
+		http.StatusInternalServerError,
+		gin.H{
+			"code":    500,
+			"message": "Internal Server Error",
+			"success": false,
+		},
+	)
+}
+
+// Recovery 捕获panic，记录日志、指标和链路，并返回500
+func Recovery(conf RecoveryConf) gin.HandlerFunc {
+	if conf.Response == nil {
+		conf.Response = defaultRecoveryResponse
+	}
+	var counter *prometheus.CounterVec
+	if conf.Prometheus != nil {
+		counter = conf.Prometheus.registerMetric(panicCnt).(*prometheus.CounterVec)
+	}
+
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			ctx := c.Request.Context()
+
+			if isBrokenPipe(r) {
+				// 客户端断开连接，无法再写响应，也不需要堆栈
+				zLog.TraceWarn(
+					ctx,
+					"HttpBrokenPipe",
+					zap.Any("error", r),
+					zap.Any("url", c.Request.URL),
+				)
+				if err, ok := r.(error); ok {
+					_ = c.Error(err)
+				}
+				c.Abort()
+				return
+			}
+
+			stack := debug.Stack()
+			c.Set(panicRecoveredKey, r)
+			zLog.TraceError(
+				ctx,
+				"HttpPanic",
+				zap.Any("panic", r),
+				zap.Any("url", c.Request.URL),
+				zap.ByteString("stack", stack),
+			)
+			if counter != nil {
+				counter.WithLabelValues(conf.Prometheus.urlLabel(c), c.Request.Method).Inc()
+			}
+			if span := trace.SpanFromContext(ctx); span.IsRecording() {
+				msg := fmt.Sprint(r)
+				span.AddEvent("exception", trace.WithAttributes(
+					attribute.String("exception.type", fmt.Sprintf("%T", r)),
+					attribute.String("exception.message", msg),
+					attribute.String("exception.stacktrace", string(stack)),
+				))
+				span.SetStatus(codes.Error, "panic: "+msg)
+			}
+			if conf.OnPanic != nil {
+				conf.OnPanic(c, r, stack)
+			}
+			conf.Response(c, r)
+		}()
+		c.Next()
+	}
+}
+
+func isBrokenPipe(r any) bool {
+	err, ok := r.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, http.ErrAbortHandler) {
+		return true
+	}
+	var ne *net.OpError
+	if !errors.As(err, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if errors.As(ne, &se) {
+		s := strings.ToLower(se.Error())
+		return strings.Contains(s, "broken pipe") || strings.Contains(s, "connection reset by peer")
+	}
+	return false
+}
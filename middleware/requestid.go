@@ -1,26 +1,145 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/RollNA/harbour/httpUtil"
+	"github.com/RollNA/harbour/zLog"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RequestIdType string
+
+const (
+	RequestIdUUIDv4 RequestIdType = "uuidv4"
+	RequestIdUUIDv7 RequestIdType = "uuidv7"
+	RequestIdULID   RequestIdType = "ulid"
+
+	// RequestIdKey gin.Context中保存请求ID的key，与header名无关
+	RequestIdKey = "X-Request-Id"
+
+	defaultRequestIdHeader = "X-Request-Id"
+	requestIdSpanAttribute = "http.request_id"
+	maxRequestIdLength     = 128
 )
 
+type RequestIdConf struct {
+	// 读取和返回请求ID的header，默认X-Request-Id，同时作为httpUtil转发请求ID的header
+	Header string `mapstructure:"header"`
+	// 默认uuidv4
+	Type RequestIdType `mapstructure:"type"`
+	// 自定义生成器，设置后忽略Type
+	Generator func() string `mapstructure:"-"`
+}
+
 func RequestId() gin.HandlerFunc {
+	return RequestIdWithConf(RequestIdConf{})
+}
+
+// RequestIdWithConf 读取或生成请求ID，写入response header、gin.Context和请求的context，
+// zLog.Trace*日志会自动带上请求ID。请求带的ID超过128个字符或包含字母、数字和"-_.:"以外的字符时重新生成
+func RequestIdWithConf(conf RequestIdConf) gin.HandlerFunc {
+	if conf.Header == "" {
+		conf.Header = defaultRequestIdHeader
+	}
+	httpUtil.RequestIdHeader = conf.Header
+	if conf.Generator == nil {
+		switch conf.Type {
+		case RequestIdUUIDv7:
+			conf.Generator = newUUIDv7
+		case RequestIdULID:
+			conf.Generator = newULID
+		default:
+			conf.Generator = uuid.NewString
+		}
+	}
+
 	return func(c *gin.Context) {
 		// Check for incoming header, use it if exists
-		requestId := c.Request.Header.Get("X-Request-Id")
+		requestId := c.Request.Header.Get(conf.Header)
 
-		// Create request id with UUID4
-		if requestId == "" {
-			u4 := uuid.New()
-			requestId = u4.String()
+		// 请求ID会写入日志、span和下游请求，不信任客户端传入的任意值
+		if !validRequestId(requestId) {
+			requestId = conf.Generator()
 		}
 
 		// Expose it for use in the application
-		c.Set("X-Request-Id", requestId)
+		c.Set(RequestIdKey, requestId)
+		c.Request = c.Request.WithContext(zLog.ContextWithRequestId(c.Request.Context(), requestId))
+		annotateRequestId(c)
 
 		// Set X-Request-Id header
-		c.Writer.Header().Set("X-Request-Id", requestId)
+		c.Writer.Header().Set(conf.Header, requestId)
 		c.Next()
 	}
 }
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		switch {
+		case b >= '0' && b <= '9', b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z':
+		case b == '-', b == '_', b == '.', b == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// annotateRequestId 把请求ID写入当前span，trace中间件在RequestId之后时由InitMiddleware在trace之后再调用一次
+func annotateRequestId(c *gin.Context) {
+	ctx := c.Request.Context()
+	requestId := zLog.RequestIdFromContext(ctx)
+	if requestId == "" {
+		return
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.String(requestIdSpanAttribute, requestId))
+	}
+}
+
+func newUUIDv7() string {
+	u, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return u.String()
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID 48位毫秒时间戳 + 80位随机数，Crockford base32编码为26个字符
+func newULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	_, _ = rand.Read(b[6:])
+
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := range out {
+		shift := uint(125 - 5*i)
+		var v uint64
+		switch {
+		case shift >= 64:
+			v = hi >> (shift - 64)
+		case shift == 0:
+			v = lo
+		default:
+			v = lo>>shift | hi<<(64-shift)
+		}
+		out[i] = crockfordBase32[v&31]
+	}
+	return string(out)
+}
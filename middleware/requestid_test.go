@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RollNA/harbour/httpUtil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIdValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIdWithConf(RequestIdConf{Header: "X-Trace-Request", Generator: func() string { return "generated" }}))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(RequestIdKey)) })
+	assert.Equal(t, "X-Trace-Request", httpUtil.RequestIdHeader)
+	t.Cleanup(func() { httpUtil.RequestIdHeader = defaultRequestIdHeader })
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"", "generated"},
+		{"0190c5b2-7f3a-7c1e-9f2d-3c4b5a6d7e8f", "0190c5b2-7f3a-7c1e-9f2d-3c4b5a6d7e8f"},
+		{"svc.a:req_1", "svc.a:req_1"},
+		{"bad\nvalue", "generated"},
+		{"<script>", "generated"},
+		{strings.Repeat("a", maxRequestIdLength+1), "generated"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header["X-Trace-Request"] = []string{tc.in}
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.want, w.Body.String(), tc.in)
+		assert.Equal(t, tc.want, w.Header().Get("X-Trace-Request"), tc.in)
+	}
+}
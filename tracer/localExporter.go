@@ -0,0 +1,96 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type TraceFileConf struct {
+	// 默认./logs/trace.jsonl
+	Path string `mapstructure:"path"`
+	// 单个文件的大小，单位MB，默认20
+	MaxSize    int  `mapstructure:"maxSize"`
+	MaxAge     int  `mapstructure:"maxAge"`
+	MaxBackups int  `mapstructure:"maxBackups"`
+	Compress   bool `mapstructure:"compress"`
+}
+
+// MEMORY模式下保存span，进程内共用
+var memoryExporter = tracetest.NewInMemoryExporter()
+
+// MemorySpans 返回MEMORY模式下已结束的span，按结束的顺序，可用于在单元测试中断言span的父子关系
+func MemorySpans() tracetest.SpanStubs {
+	return memoryExporter.GetSpans()
+}
+
+// ResetMemorySpans 清空MEMORY模式下保存的span
+func ResetMemorySpans() {
+	memoryExporter.Reset()
+}
+
+func isLocalProtocol(protocol TransportProtocolType) bool {
+	switch protocol {
+	case TransportProtocolSTDOUT, TransportProtocolFILE, TransportProtocolMEMORY:
+		return true
+	}
+	return false
+}
+
+// newLocalExporter 不需要collector的exporter，用于本地开发和测试
+func newLocalExporter(conf TraceConf, protocol TransportProtocolType) (sdktrace.SpanExporter, error) {
+	switch protocol {
+	case TransportProtocolSTDOUT:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case TransportProtocolFILE:
+		fileConf := conf.File
+		if fileConf.Path == "" {
+			fileConf.Path = "./logs/trace.jsonl"
+		}
+		if fileConf.MaxSize <= 0 {
+			fileConf.MaxSize = 20
+		}
+		w := &lumberjack.Logger{
+			Filename:   fileConf.Path,
+			MaxSize:    fileConf.MaxSize,
+			MaxAge:     fileConf.MaxAge,
+			MaxBackups: fileConf.MaxBackups,
+			Compress:   fileConf.Compress,
+		}
+		// 不格式化时每个span占一行
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, err
+		}
+		return &closingExporter{SpanExporter: exporter, closer: w}, nil
+	case TransportProtocolMEMORY:
+		return keepOnShutdownExporter{memoryExporter}, nil
+	}
+	return nil, errors.New("unknown local trace exporter " + string(protocol))
+}
+
+// closingExporter 关闭exporter后关闭文件
+type closingExporter struct {
+	sdktrace.SpanExporter
+	closer io.Closer
+}
+
+func (e *closingExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.closer.Close())
+}
+
+// keepOnShutdownExporter InMemoryExporter在Shutdown时会清空span，
+// 这里保留span，测试中可以在shutdown刷新之后再读取
+type keepOnShutdownExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (keepOnShutdownExporter) Shutdown(context.Context) error {
+	return nil
+}
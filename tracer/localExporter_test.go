@@ -0,0 +1,74 @@
+package tracer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+)
+
+func TestMemoryExporterSpanTree(t *testing.T) {
+	shutdown, err := InitTracerE(TraceConf{ServiceName: "test", TransportProtocol: TransportProtocolMEMORY})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+	ResetMemorySpans()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware("test"))
+	r.GET("/users/:id", func(c *gin.Context) {
+		_, span := otel.Tracer("test").Start(c.Request.Context(), "loadUser")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	spans := MemorySpans()
+	assert.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "loadUser", child.Name)
+	assert.Equal(t, "/users/:id", server.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID())
+	assert.Equal(t, server.SpanContext.TraceID(), child.SpanContext.TraceID())
+
+	// shutdown之后仍然可以读取
+	assert.NoError(t, shutdown(context.Background()))
+	assert.Len(t, MemorySpans(), 2)
+}
+
+func TestFileExporterWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	shutdown, err := InitTracerE(TraceConf{
+		ServiceName:       "test",
+		TransportProtocol: TransportProtocolFILE,
+		File:              TraceFileConf{Path: path},
+	})
+	assert.NoError(t, err)
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "root")
+	_, child := otel.Tracer("test").Start(ctx, "child")
+	child.End()
+	root.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var span struct{ Name string }
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &span))
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"child", "root"}, names)
+}
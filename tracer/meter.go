@@ -0,0 +1,114 @@
+package tracer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/credentials"
+)
+
+type MeterConf struct {
+	// 为空时使用OTEL_SERVICE_NAME
+	ServiceName string `mapstructure:"serviceName"`
+	// OTLP collector地址，为空时使用OTEL_EXPORTER_OTLP_METRICS_ENDPOINT或OTEL_EXPORTER_OTLP_ENDPOINT，
+	// 都为空时不通过OTLP导出
+	CollectorURL string `mapstructure:"collectorURL"`
+	Insecure     bool   `mapstructure:"insecure"`
+	// 为空时按OTEL_EXPORTER_OTLP_PROTOCOL选择，默认GRPC
+	TransportProtocol TransportProtocolType `mapstructure:"transportProtocol"`
+	// OTLP导出间隔，为空时使用OTEL_METRIC_EXPORT_INTERVAL，默认60s
+	ExportInterval time.Duration `mapstructure:"exportInterval"`
+	ResourceConf   `mapstructure:",squash"`
+
+	// 把OTel指标桥接到Prometheus registry，和Prometheus中间件一起通过/metrics暴露
+	Prometheus bool `mapstructure:"prometheus"`
+	// 默认prometheus.DefaultRegisterer，需与Prometheus中间件使用同一个registry
+	PrometheusRegisterer prometheus.Registerer `mapstructure:"-"`
+}
+
+// InitMeter 初始化全局MeterProvider，redisotel、gorm、otelhttp等instrumentation的指标才会被导出。
+// 可以同时启用OTLP和Prometheus，返回的函数在退出前调用以导出剩余指标
+func InitMeter(conf MeterConf) (func(context.Context) error, error) {
+	var readers []sdkmetric.Reader
+
+	if conf.CollectorURL != "" || otlpEnv("METRICS", "ENDPOINT") != "" {
+		exporter, err := newMetricExporter(conf)
+		if err != nil {
+			return nil, err
+		}
+		var opts []sdkmetric.PeriodicReaderOption
+		if conf.ExportInterval > 0 {
+			opts = append(opts, sdkmetric.WithInterval(conf.ExportInterval))
+		}
+		readers = append(readers, sdkmetric.NewPeriodicReader(exporter, opts...))
+	}
+
+	if conf.Prometheus {
+		registerer := conf.PrometheusRegisterer
+		if registerer == nil {
+			registerer = prometheus.DefaultRegisterer
+		}
+		exporter, err := otelprom.New(otelprom.WithRegisterer(registerer))
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, exporter)
+	}
+
+	if len(readers) == 0 {
+		return nil, errors.New("no metric exporter configured, set CollectorURL, OTEL_EXPORTER_OTLP_ENDPOINT or Prometheus")
+	}
+
+	resources, err := newResource(conf.ServiceName, conf.ResourceConf)
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdkmetric.Option{sdkmetric.WithResource(resources)}
+	for _, r := range readers {
+		opts = append(opts, sdkmetric.WithReader(r))
+	}
+	provider := sdkmetric.NewMeterProvider(opts...)
+	otel.SetMeterProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func newMetricExporter(conf MeterConf) (sdkmetric.Exporter, error) {
+	protocol := transportProtocol(conf.TransportProtocol, "METRICS")
+	if err := validateCollectorURL(protocol, conf.CollectorURL, "METRICS"); err != nil {
+		return nil, err
+	}
+	switch protocol {
+	case TransportProtocolHTTP:
+		var opts []otlpmetrichttp.Option
+		if conf.CollectorURL != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(conf.CollectorURL))
+		}
+		switch exporterSecurity(conf.CollectorURL, conf.Insecure) {
+		case otlpSecurityInsecure:
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		case otlpSecurityTLS:
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(&tls.Config{}))
+		}
+		return otlpmetrichttp.New(context.Background(), opts...)
+	default:
+		var opts []otlpmetricgrpc.Option
+		if conf.CollectorURL != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(conf.CollectorURL))
+		}
+		switch exporterSecurity(conf.CollectorURL, conf.Insecure) {
+		case otlpSecurityInsecure:
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		case otlpSecurityTLS:
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		}
+		return otlpmetricgrpc.New(context.Background(), opts...)
+	}
+}
@@ -0,0 +1,114 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type PropagatorType string
+
+const (
+	// W3C traceparent/tracestate
+	PropagatorTraceContext PropagatorType = "tracecontext"
+	// W3C baggage
+	PropagatorBaggage PropagatorType = "baggage"
+	// B3单header(b3)，提取时同时支持多header
+	PropagatorB3 PropagatorType = "b3"
+	// B3多header(X-B3-TraceId等)，提取时同时支持单header
+	PropagatorB3Multi PropagatorType = "b3multi"
+	// Jaeger uber-trace-id
+	PropagatorJaeger PropagatorType = "jaeger"
+	// AWS X-Ray X-Amzn-Trace-Id，启用后trace id按X-Ray的格式生成
+	PropagatorXRay PropagatorType = "xray"
+)
+
+var defaultPropagators = []PropagatorType{PropagatorTraceContext, PropagatorBaggage}
+
+// NewPropagator 按顺序组合propagator：提取时依次尝试各个trace格式，使用第一个提取到的span，
+// baggage总是提取；注入时写入所有格式，下游不论使用哪种格式都能串联
+func NewPropagator(types []PropagatorType) (propagation.TextMapPropagator, error) {
+	if len(types) == 0 {
+		types = defaultPropagators
+	}
+	p := &orderedPropagator{}
+	seen := make(map[PropagatorType]struct{}, len(types))
+	for _, t := range types {
+		t = PropagatorType(strings.ToLower(strings.TrimSpace(string(t))))
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+
+		switch t {
+		case PropagatorTraceContext:
+			p.trace = append(p.trace, propagation.TraceContext{})
+		case PropagatorBaggage:
+			p.baggage = append(p.baggage, propagation.Baggage{})
+		case PropagatorB3:
+			p.trace = append(p.trace, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PropagatorB3Multi:
+			p.trace = append(p.trace, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case PropagatorJaeger:
+			p.trace = append(p.trace, jaeger.Jaeger{})
+		case PropagatorXRay:
+			p.trace = append(p.trace, xray.Propagator{})
+		default:
+			return nil, fmt.Errorf("unknown propagator %q", t)
+		}
+	}
+	return p, nil
+}
+
+func usesXRay(types []PropagatorType) bool {
+	for _, t := range types {
+		if PropagatorType(strings.ToLower(strings.TrimSpace(string(t)))) == PropagatorXRay {
+			return true
+		}
+	}
+	return false
+}
+
+type orderedPropagator struct {
+	trace   []propagation.TextMapPropagator
+	baggage []propagation.TextMapPropagator
+}
+
+func (p *orderedPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	for _, prop := range p.trace {
+		prop.Inject(ctx, carrier)
+	}
+	for _, prop := range p.baggage {
+		prop.Inject(ctx, carrier)
+	}
+}
+
+func (p *orderedPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	// 没有对应header时propagator原样返回ctx，ctx中已有的远端span不能当作提取结果
+	prev := trace.SpanContextFromContext(ctx)
+	for _, prop := range p.trace {
+		extracted := prop.Extract(ctx, carrier)
+		if sc := trace.SpanContextFromContext(extracted); sc.IsValid() && sc.IsRemote() && !sc.Equal(prev) {
+			ctx = extracted
+			break
+		}
+	}
+	for _, prop := range p.baggage {
+		ctx = prop.Extract(ctx, carrier)
+	}
+	return ctx
+}
+
+func (p *orderedPropagator) Fields() []string {
+	var fields []string
+	for _, prop := range append(p.trace[:len(p.trace):len(p.trace)], p.baggage...) {
+		fields = append(fields, prop.Fields()...)
+	}
+	return fields
+}
@@ -0,0 +1,29 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOrderedPropagatorIgnoresStaleParent(t *testing.T) {
+	p, err := NewPropagator([]PropagatorType{PropagatorTraceContext, PropagatorB3})
+	assert.NoError(t, err)
+
+	// ctx中已有上一次提取的远端span，本次只带b3 header
+	stale, err := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "")
+	assert.NoError(t, err)
+	carrier := propagation.MapCarrier{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"}
+
+	sc := trace.SpanContextFromContext(p.Extract(stale, carrier))
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", sc.TraceID().String())
+	assert.Equal(t, "e457b5a2e4d86bd1", sc.SpanID().String())
+	assert.True(t, sc.IsRemote())
+
+	// 没有任何header时保留原有的span
+	sc = trace.SpanContextFromContext(p.Extract(stale, propagation.MapCarrier{}))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+}
@@ -0,0 +1,180 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const remoteTracerName = "github.com/RollNA/harbour/tracer"
+
+var (
+	ErrInvalidTraceparent = errors.New("invalid traceparent")
+	ErrInvalidTraceId     = errors.New("invalid trace id")
+	ErrInvalidSpanId      = errors.New("invalid span id")
+	// 消息header中没有可以提取的trace
+	ErrNoRemoteParent = errors.New("no remote parent in headers")
+)
+
+// ContextWithRemoteParent 用原始的trace id(32位hex)和span id(16位hex)构造远程父span，
+// 全0或格式不对时返回错误
+func ContextWithRemoteParent(ctx context.Context, traceId, spanId string, sampled bool) (context.Context, error) {
+	tid, err := trace.TraceIDFromHex(traceId)
+	if err != nil {
+		return ctx, fmt.Errorf("%w %q", ErrInvalidTraceId, traceId)
+	}
+	sid, err := trace.SpanIDFromHex(spanId)
+	if err != nil {
+		return ctx, fmt.Errorf("%w %q", ErrInvalidSpanId, spanId)
+	}
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: flags,
+		Remote:     true,
+	})
+	return trace.ContextWithRemoteSpanContext(ctx, sc), nil
+}
+
+// ContextWithTraceparent 用W3C traceparent(如00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01)
+// 和可选的tracestate构造远程父span
+func ContextWithTraceparent(ctx context.Context, traceparent, tracestate string) (context.Context, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[3]) != 2 {
+		return ctx, fmt.Errorf("%w %q", ErrInvalidTraceparent, traceparent)
+	}
+	// 版本00只有4段，更高的版本允许在后面追加字段
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) || !isLowerHex(parts[0]) || !isLowerHex(parts[3]) {
+		return ctx, fmt.Errorf("%w %q", ErrInvalidTraceparent, traceparent)
+	}
+	if len(parts[1]) != 32 || !isLowerHex(parts[1]) || len(parts[2]) != 16 || !isLowerHex(parts[2]) {
+		return ctx, fmt.Errorf("%w %q", ErrInvalidTraceparent, traceparent)
+	}
+
+	remote, err := ContextWithRemoteParent(ctx, parts[1], parts[2], parseHexByte(parts[3])&1 == 1)
+	if err != nil {
+		return ctx, fmt.Errorf("%w %q: %w", ErrInvalidTraceparent, traceparent, err)
+	}
+	if tracestate == "" {
+		return remote, nil
+	}
+	ts, err := trace.ParseTraceState(tracestate)
+	if err != nil {
+		return ctx, fmt.Errorf("invalid tracestate %q: %w", tracestate, err)
+	}
+	sc := trace.SpanContextFromContext(remote).WithTraceState(ts)
+	return trace.ContextWithRemoteSpanContext(ctx, sc), nil
+}
+
+// ContextFromHeaders 按全局propagator(见TraceConf.Propagators)从Kafka、AMQP等消息的header中提取远程父span，
+// baggage同时被提取
+func ContextFromHeaders(ctx context.Context, headers propagation.TextMapCarrier) (context.Context, error) {
+	remote := otel.GetTextMapPropagator().Extract(ctx, headers)
+	if sc := trace.SpanContextFromContext(remote); !sc.IsValid() || !sc.IsRemote() {
+		return ctx, ErrNoRemoteParent
+	}
+	return remote, nil
+}
+
+// InjectHeaders 把ctx中的span按全局propagator写入消息header，用于生产者
+func InjectHeaders(ctx context.Context, headers propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+}
+
+// MessageHeaderCarrier 适配值为string或[]byte的消息header，如AMQP的Table、转换为map的Kafka header
+type MessageHeaderCarrier map[string]any
+
+func (c MessageHeaderCarrier) Get(key string) string {
+	v, ok := c[key]
+	if !ok {
+		// 部分客户端会改变header的大小写
+		for k, val := range c {
+			if strings.EqualFold(k, key) {
+				v, ok = val, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return ""
+	}
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	}
+	return ""
+}
+
+func (c MessageHeaderCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c MessageHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type ConsumerRelation int
+
+const (
+	// 作为生产者span的子span，适合一条消息对应一次处理
+	ConsumerAsChild ConsumerRelation = iota
+	// 开始新的trace并link到生产者span，适合批量消费或消费与生产相隔很久的情况
+	ConsumerAsLink
+)
+
+// StartConsumerSpan 开始一个consumer类型的span，producer无效时开始新的trace
+func StartConsumerSpan(
+	ctx context.Context,
+	name string,
+	producer trace.SpanContext,
+	relation ConsumerRelation,
+	opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	opts = append([]trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindConsumer)}, opts...)
+	if producer.IsValid() {
+		switch relation {
+		case ConsumerAsLink:
+			opts = append(opts, trace.WithNewRoot(), trace.WithLinks(trace.Link{SpanContext: producer}))
+		default:
+			ctx = trace.ContextWithRemoteSpanContext(ctx, producer)
+		}
+	}
+	return otel.Tracer(remoteTracerName).Start(ctx, name, opts...)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHexByte(s string) byte {
+	var b byte
+	for _, c := range s {
+		b <<= 4
+		if c <= '9' {
+			b |= byte(c - '0')
+		} else {
+			b |= byte(c-'a') + 10
+		}
+	}
+	return b
+}
@@ -0,0 +1,93 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContextWithTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	ctx, err := ContextWithTraceparent(context.Background(), valid, "vendor=abc")
+	assert.NoError(t, err)
+	sc := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+	assert.True(t, sc.IsRemote())
+	assert.True(t, sc.IsSampled())
+	assert.Equal(t, "abc", sc.TraceState().Get("vendor"))
+
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ContextWithTraceparent(context.Background(), tp, "")
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, tp)
+	}
+}
+
+func TestContextWithRemoteParent(t *testing.T) {
+	_, err := ContextWithRemoteParent(context.Background(), "xyz", "00f067aa0ba902b7", true)
+	assert.ErrorIs(t, err, ErrInvalidTraceId)
+	_, err = ContextWithRemoteParent(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736", "", true)
+	assert.ErrorIs(t, err, ErrInvalidSpanId)
+
+	// 不合法的ID不再被替换为固定的假trace
+	ctx := InitContextWithTrace(context.Background(), "bad", "bad")
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestContextFromHeaders(t *testing.T) {
+	shutdown, err := InitTracerE(TraceConf{TransportProtocol: TransportProtocolMEMORY})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+
+	_, err = ContextFromHeaders(context.Background(), MessageHeaderCarrier{})
+	assert.ErrorIs(t, err, ErrNoRemoteParent)
+
+	producerCtx, producer := otel.Tracer("test").Start(context.Background(), "produce", trace.WithSpanKind(trace.SpanKindProducer))
+	headers := MessageHeaderCarrier{}
+	InjectHeaders(producerCtx, headers)
+	producer.End()
+
+	// Kafka等客户端的header值是[]byte
+	headers["traceparent"] = []byte(headers.Get("traceparent"))
+	ctx, err := ContextFromHeaders(context.Background(), headers)
+	assert.NoError(t, err)
+	assert.Equal(t, producer.SpanContext().TraceID(), trace.SpanContextFromContext(ctx).TraceID())
+}
+
+func TestStartConsumerSpan(t *testing.T) {
+	shutdown, err := InitTracerE(TraceConf{TransportProtocol: TransportProtocolMEMORY})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+	ResetMemorySpans()
+
+	ctx, _ := ContextWithRemoteParent(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true)
+	producer := trace.SpanContextFromContext(ctx)
+
+	_, child := StartConsumerSpan(context.Background(), "child", producer, ConsumerAsChild)
+	child.End()
+	_, linked := StartConsumerSpan(context.Background(), "linked", producer, ConsumerAsLink)
+	linked.End()
+
+	spans := MemorySpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, trace.SpanKindConsumer, spans[0].SpanKind)
+	assert.Equal(t, producer.TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, producer.SpanID(), spans[0].Parent.SpanID())
+
+	assert.NotEqual(t, producer.TraceID(), spans[1].SpanContext.TraceID())
+	assert.False(t, spans[1].Parent.IsValid())
+	assert.Len(t, spans[1].Links, 1)
+	assert.Equal(t, producer.SpanID(), spans[1].Links[0].SpanContext.SpanID())
+}
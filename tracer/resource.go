@@ -0,0 +1,116 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// 进程内链路和指标共用同一个实例ID
+var defaultInstanceId = uuid.NewString()
+
+// ResourceConf 链路和指标共用的resource属性，优先级为：显式配置 > OTEL_SERVICE_NAME、OTEL_RESOURCE_ATTRIBUTES > 自动探测
+type ResourceConf struct {
+	ServiceVersion string `mapstructure:"serviceVersion"`
+	// deployment.environment，如prod、staging
+	Environment string `mapstructure:"environment"`
+	// service.instance.id，为空时每个进程生成一个uuid
+	InstanceId string `mapstructure:"instanceId"`
+	// 附加的resource属性
+	Attributes map[string]string `mapstructure:"attributes"`
+	// 关闭host、os、process、container的自动探测
+	DisableDetectors bool `mapstructure:"disableDetectors"`
+}
+
+func newResource(serviceName string, conf ResourceConf) (*resource.Resource, error) {
+	opts := []resource.Option{
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+	}
+	if !conf.DisableDetectors {
+		// 不包含命令行参数和进程所有者，避免泄露敏感信息
+		opts = append(opts,
+			resource.WithHost(),
+			resource.WithOS(),
+			resource.WithContainer(),
+			resource.WithProcessPID(),
+			resource.WithProcessExecutableName(),
+			resource.WithProcessRuntimeName(),
+			resource.WithProcessRuntimeVersion(),
+		)
+	}
+	opts = append(opts,
+		resource.WithAttributes(semconv.ServiceInstanceID(defaultInstanceId)),
+		resource.WithFromEnv(),
+	)
+
+	var attrs []attribute.KeyValue
+	for k, v := range conf.Attributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	if serviceName != "" {
+		attrs = append(attrs, semconv.ServiceName(serviceName))
+	}
+	if conf.ServiceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(conf.ServiceVersion))
+	}
+	if conf.Environment != "" {
+		attrs = append(attrs, semconv.DeploymentEnvironment(conf.Environment))
+	}
+	if conf.InstanceId != "" {
+		attrs = append(attrs, semconv.ServiceInstanceID(conf.InstanceId))
+	}
+	opts = append(opts, resource.WithAttributes(attrs...))
+
+	res, err := resource.New(context.Background(), opts...)
+	if errors.Is(err, resource.ErrPartialResource) {
+		// 部分探测失败(如不在容器中)时仍然使用已经得到的属性
+		return res, nil
+	}
+	return res, err
+}
+
+// otlpEnv 读取OTEL_EXPORTER_OTLP_{signal}_{name}，为空时读取OTEL_EXPORTER_OTLP_{name}
+func otlpEnv(signal, name string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_" + name); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// transportProtocol 未配置时按OTEL_EXPORTER_OTLP_PROTOCOL选择，默认GRPC
+func transportProtocol(protocol TransportProtocolType, signal string) TransportProtocolType {
+	if protocol != "" {
+		return TransportProtocolType(strings.ToUpper(string(protocol)))
+	}
+	if strings.HasPrefix(otlpEnv(signal, "PROTOCOL"), "http") {
+		return TransportProtocolHTTP
+	}
+	return TransportProtocolGRPC
+}
+
+type otlpSecurity int
+
+const (
+	// 不设置，由OTEL_EXPORTER_OTLP_INSECURE等环境变量决定
+	otlpSecurityEnv otlpSecurity = iota
+	otlpSecurityInsecure
+	otlpSecurityTLS
+)
+
+// exporterSecurity 只能二选一：TLS证书会覆盖WithInsecure，同时设置时Insecure不生效
+func exporterSecurity(collectorURL string, insecure bool) otlpSecurity {
+	if insecure {
+		return otlpSecurityInsecure
+	}
+	if collectorURL != "" {
+		return otlpSecurityTLS
+	}
+	return otlpSecurityEnv
+}
@@ -0,0 +1,24 @@
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporterSecurity(t *testing.T) {
+	cases := []struct {
+		name         string
+		collectorURL string
+		insecure     bool
+		want         otlpSecurity
+	}{
+		{"insecure collector", "otel-collector:4317", true, otlpSecurityInsecure},
+		{"tls collector", "otel-collector:4317", false, otlpSecurityTLS},
+		{"insecure from conf, endpoint from env", "", true, otlpSecurityInsecure},
+		{"both from env", "", false, otlpSecurityEnv},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, exporterSecurity(tc.collectorURL, tc.insecure), tc.name)
+	}
+}
@@ -0,0 +1,224 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type SamplingConf struct {
+	// 新trace的采样比例，0~1，为nil时全部采样；有父span时跟随父span的决定
+	Ratio *float64 `mapstructure:"ratio"`
+	// 每秒最多新采样的trace数，<=0时不限制
+	RateLimit float64 `mapstructure:"rateLimit"`
+	// 按路由的采样规则，按顺序匹配，先匹配的生效，优先于父span的决定
+	Rules []SamplingRule `mapstructure:"rules"`
+	// 请求带该header且值不为空、0、false时总是采样，如X-Debug-Trace，为空时不启用
+	DebugHeader string `mapstructure:"debugHeader"`
+}
+
+type SamplingRule struct {
+	// 匹配http.route或url路径，以*结尾时按前缀匹配，如"/metrics"、"/health*"
+	Route string `mapstructure:"route"`
+	// 0表示不采样，1表示全部采样
+	Ratio float64 `mapstructure:"ratio"`
+}
+
+// 用于匹配路由的span属性，依次为otelgin的http.route、旧版semconv的http.target和新版的url.path
+var samplingRouteKeys = []attribute.Key{"http.route", "http.target", "url.path"}
+
+type forceSampleKey struct{}
+
+// ContextWithForceSample 标记ctx中开始的新span总是采样，用于调试请求
+func ContextWithForceSample(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceSampleKey{}, true)
+}
+
+func forceSampled(ctx context.Context) bool {
+	v, _ := ctx.Value(forceSampleKey{}).(bool)
+	return v
+}
+
+// IsDebugValue 判断调试header的值是否开启
+func IsDebugValue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "0", "false", "off", "no":
+		return false
+	}
+	return true
+}
+
+// DynamicSampler 可以在运行时通过Update替换配置的采样器
+type DynamicSampler struct {
+	state atomic.Pointer[samplerState]
+}
+
+type samplerState struct {
+	conf    SamplingConf
+	root    sdktrace.Sampler
+	rules   []compiledRule
+	limiter *traceRateLimiter
+}
+
+type compiledRule struct {
+	route   string
+	prefix  bool
+	sampler sdktrace.Sampler
+}
+
+func NewDynamicSampler(conf SamplingConf) (*DynamicSampler, error) {
+	s := &DynamicSampler{}
+	if err := s.Update(conf); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update 替换采样配置，配置不合法时保留原配置并返回错误
+func (s *DynamicSampler) Update(conf SamplingConf) error {
+	state := &samplerState{conf: conf, root: sdktrace.AlwaysSample()}
+	if conf.Ratio != nil {
+		if *conf.Ratio < 0 || *conf.Ratio > 1 {
+			return fmt.Errorf("sampling ratio %v out of range [0, 1]", *conf.Ratio)
+		}
+		state.root = sdktrace.TraceIDRatioBased(*conf.Ratio)
+	}
+	for _, r := range conf.Rules {
+		if r.Ratio < 0 || r.Ratio > 1 {
+			return fmt.Errorf("sampling ratio %v of route %q out of range [0, 1]", r.Ratio, r.Route)
+		}
+		route, prefix := strings.CutSuffix(r.Route, "*")
+		state.rules = append(state.rules, compiledRule{
+			route:   route,
+			prefix:  prefix,
+			sampler: sdktrace.TraceIDRatioBased(r.Ratio),
+		})
+	}
+	if conf.RateLimit > 0 {
+		state.limiter = newTraceRateLimiter(conf.RateLimit)
+	}
+	s.state.Store(state)
+	return nil
+}
+
+// Conf 当前生效的配置
+func (s *DynamicSampler) Conf() SamplingConf {
+	return s.state.Load().conf
+}
+
+func (s *DynamicSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	state := s.state.Load()
+	psc := trace.SpanContextFromContext(p.ParentContext)
+
+	if forceSampled(p.ParentContext) {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample, Tracestate: psc.TraceState()}
+	}
+	if rule, ok := state.matchRule(p.Attributes); ok {
+		return state.limit(rule.sampler.ShouldSample(p))
+	}
+	if psc.IsValid() {
+		// 跟随父span的决定，不受限流影响，保证trace完整
+		decision := sdktrace.Drop
+		if psc.IsSampled() {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{Decision: decision, Tracestate: psc.TraceState()}
+	}
+	return state.limit(state.root.ShouldSample(p))
+}
+
+func (s *DynamicSampler) Description() string {
+	conf := s.Conf()
+	ratio := 1.0
+	if conf.Ratio != nil {
+		ratio = *conf.Ratio
+	}
+	return fmt.Sprintf("DynamicSampler{ratio=%g,rateLimit=%g,rules=%d}", ratio, conf.RateLimit, len(conf.Rules))
+}
+
+func (s *samplerState) matchRule(attrs []attribute.KeyValue) (compiledRule, bool) {
+	if len(s.rules) == 0 {
+		return compiledRule{}, false
+	}
+	for _, key := range samplingRouteKeys {
+		for _, kv := range attrs {
+			if kv.Key != key {
+				continue
+			}
+			v := kv.Value.AsString()
+			if v == "" {
+				continue
+			}
+			for _, r := range s.rules {
+				if v == r.route || (r.prefix && strings.HasPrefix(v, r.route)) {
+					return r, true
+				}
+			}
+		}
+	}
+	return compiledRule{}, false
+}
+
+func (s *samplerState) limit(res sdktrace.SamplingResult) sdktrace.SamplingResult {
+	if res.Decision == sdktrace.RecordAndSample && s.limiter != nil && !s.limiter.allow() {
+		res.Decision = sdktrace.Drop
+	}
+	return res
+}
+
+// traceRateLimiter 令牌桶，桶容量为每秒的速率
+type traceRateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTraceRateLimiter(rate float64) *traceRateLimiter {
+	return &traceRateLimiter{rate: rate, tokens: max(rate, 1), last: time.Now(), now: time.Now}
+}
+
+func (l *traceRateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens = min(max(l.rate, 1), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+var (
+	defaultSampler     *DynamicSampler
+	defaultSamplerOnce sync.Once
+)
+
+// globalSampler InitTracer使用的采样器，UpdateSampling修改的就是它
+func globalSampler() *DynamicSampler {
+	defaultSamplerOnce.Do(func() {
+		defaultSampler, _ = NewDynamicSampler(SamplingConf{})
+	})
+	return defaultSampler
+}
+
+// UpdateSampling 热更新InitTracer使用的采样配置，不需要重启进程，
+// 可以在配置中心或配置文件变更的回调中调用
+func UpdateSampling(conf SamplingConf) error {
+	return globalSampler().Update(conf)
+}
+
+// DebugHeader 当前配置的调试header，为空时不启用
+func DebugHeader() string {
+	return globalSampler().Conf().DebugHeader
+}
@@ -0,0 +1,130 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var testTraceID = trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+
+func ratio(v float64) *float64 { return &v }
+
+func remoteParent(sampled bool) context.Context {
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    testTraceID,
+		SpanID:     trace.SpanID{0, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: flags,
+		Remote:     true,
+	})
+	return trace.ContextWithRemoteSpanContext(context.Background(), sc)
+}
+
+func sampled(s sdktrace.Sampler, ctx context.Context, attrs ...attribute.KeyValue) bool {
+	res := s.ShouldSample(sdktrace.SamplingParameters{ParentContext: ctx, TraceID: testTraceID, Attributes: attrs})
+	return res.Decision == sdktrace.RecordAndSample
+}
+
+func TestDynamicSamplerRules(t *testing.T) {
+	s, err := NewDynamicSampler(SamplingConf{
+		Ratio: ratio(0),
+		Rules: []SamplingRule{
+			{Route: "/metrics", Ratio: 0},
+			{Route: "/health*", Ratio: 0},
+			{Route: "/orders/:id", Ratio: 1},
+		},
+	})
+	assert.NoError(t, err)
+	bg := context.Background()
+
+	cases := []struct {
+		name  string
+		ctx   context.Context
+		attrs []attribute.KeyValue
+		want  bool
+	}{
+		{"root ratio", bg, nil, false},
+		{"exact rule", bg, []attribute.KeyValue{attribute.String("http.route", "/orders/:id")}, true},
+		{"legacy target key", bg, []attribute.KeyValue{attribute.String("http.target", "/orders/:id")}, true},
+		{"url path key", bg, []attribute.KeyValue{attribute.String("url.path", "/orders/:id")}, true},
+		{"exact rule is not a prefix", remoteParent(true), []attribute.KeyValue{attribute.String("http.route", "/metrics/extra")}, true},
+		{"prefix rule", remoteParent(true), []attribute.KeyValue{attribute.String("http.route", "/healthz")}, false},
+		{"rule beats sampled parent", remoteParent(true), []attribute.KeyValue{attribute.String("http.route", "/metrics")}, false},
+		{"rule beats unsampled parent", remoteParent(false), []attribute.KeyValue{attribute.String("http.route", "/orders/:id")}, true},
+		{"sampled parent", remoteParent(true), []attribute.KeyValue{attribute.String("http.route", "/users")}, true},
+		{"unsampled parent", remoteParent(false), nil, false},
+		{"forced", ContextWithForceSample(bg), []attribute.KeyValue{attribute.String("http.route", "/metrics")}, true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, sampled(s, tc.ctx, tc.attrs...), tc.name)
+	}
+
+	s, err = NewDynamicSampler(SamplingConf{Ratio: ratio(1)})
+	assert.NoError(t, err)
+	// 没有配置规则时跟随父span
+	assert.False(t, sampled(s, remoteParent(false)))
+	assert.True(t, sampled(s, bg))
+}
+
+func TestTraceRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newTraceRateLimiter(2)
+	l.last = now
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.allow())
+	assert.True(t, l.allow())
+	assert.False(t, l.allow())
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.allow())
+	assert.False(t, l.allow())
+	// 空闲后最多积累1秒的令牌
+	now = now.Add(time.Minute)
+	assert.True(t, l.allow())
+	assert.True(t, l.allow())
+	assert.False(t, l.allow())
+}
+
+func TestDynamicSamplerRateLimit(t *testing.T) {
+	s, err := NewDynamicSampler(SamplingConf{RateLimit: 1})
+	assert.NoError(t, err)
+	now := time.Unix(1000, 0)
+	limiter := s.state.Load().limiter
+	limiter.last = now
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, sampled(s, context.Background()))
+	assert.False(t, sampled(s, context.Background()))
+	// 有父span的请求不受限流影响
+	assert.True(t, sampled(s, remoteParent(true)))
+	now = now.Add(time.Second)
+	assert.True(t, sampled(s, context.Background()))
+}
+
+func TestDynamicSamplerUpdate(t *testing.T) {
+	s, err := NewDynamicSampler(SamplingConf{Ratio: ratio(0)})
+	assert.NoError(t, err)
+	assert.False(t, sampled(s, context.Background()))
+
+	assert.NoError(t, s.Update(SamplingConf{Ratio: ratio(1), DebugHeader: "X-Debug-Trace"}))
+	assert.True(t, sampled(s, context.Background()))
+	assert.Equal(t, "X-Debug-Trace", s.Conf().DebugHeader)
+
+	// 不合法的配置不替换当前配置
+	assert.Error(t, s.Update(SamplingConf{Ratio: ratio(1.5)}))
+	assert.Error(t, s.Update(SamplingConf{Rules: []SamplingRule{{Route: "/a", Ratio: -1}}}))
+	assert.True(t, sampled(s, context.Background()))
+	assert.Equal(t, "X-Debug-Trace", s.Conf().DebugHeader)
+
+	_, err = NewDynamicSampler(SamplingConf{Ratio: ratio(-0.1)})
+	assert.Error(t, err)
+}
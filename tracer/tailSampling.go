@@ -0,0 +1,288 @@
+package tracer
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RollNA/harbour/routine"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTailDecisionWait = 10 * time.Second
+	defaultTailMaxTraces    = 10000
+	defaultTailMaxSpans     = 100000
+
+	tailMeterName = "github.com/RollNA/harbour/tracer"
+)
+
+// trace保留或丢弃的原因，作为指标的decision属性
+const (
+	TailDecisionError   = "error"
+	TailDecisionLatency = "latency"
+	TailDecisionBase    = "base"
+	TailDecisionDropped = "dropped"
+)
+
+// span被丢弃的原因，作为指标的reason属性
+const (
+	tailDropSampledOut = "sampled_out"
+	tailDropLate       = "late"
+)
+
+type TailSamplingConf struct {
+	// 从trace的第一个span结束起最多缓冲多久，超时后按已收到的span决策，默认10s。
+	// 本进程的根span结束时会立即决策
+	DecisionWait time.Duration `mapstructure:"decisionWait"`
+	// 任意span耗时达到该值时保留整个trace，<=0时不按耗时保留
+	LatencyThreshold time.Duration `mapstructure:"latencyThreshold"`
+	// 没有错误和慢span的trace的保留比例，0~1，按trace id决策，多个服务的结果一致
+	BaseRate float64 `mapstructure:"baseRate"`
+	// 最多同时缓冲的trace数，默认10000，超出时最早的trace提前决策
+	MaxTraces int `mapstructure:"maxTraces"`
+	// 最多同时缓冲的span数，默认100000，超出时最早的trace提前决策
+	MaxSpans int `mapstructure:"maxSpans"`
+	// 上报保留和丢弃数量，默认otel.GetMeterProvider()
+	MeterProvider metric.MeterProvider `mapstructure:"-"`
+}
+
+// TailSamplingProcessor 按trace缓冲span，根据错误和耗时决定整条trace是否交给下游processor导出。
+// 只能看到头部采样保留的span，启用时头部采样比例应设为1，由BaseRate控制普通trace的比例
+type TailSamplingProcessor struct {
+	next sdktrace.SpanProcessor
+	conf TailSamplingConf
+	base sdktrace.Sampler
+
+	mu      sync.Mutex
+	traces  map[trace.TraceID]*list.Element
+	order   *list.List // *tailTrace，按第一个span结束的时间排序
+	spans   int
+	decided map[trace.TraceID]tailDecision
+
+	traceCounter metric.Int64Counter
+	dropCounter  metric.Int64Counter
+	evictCounter metric.Int64Counter
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type tailTrace struct {
+	id       trace.TraceID
+	first    time.Time
+	spans    []sdktrace.ReadOnlySpan
+	decision string
+}
+
+type tailDecision struct {
+	keep    bool
+	expires time.Time
+}
+
+func NewTailSamplingProcessor(next sdktrace.SpanProcessor, conf TailSamplingConf) *TailSamplingProcessor {
+	if conf.DecisionWait <= 0 {
+		conf.DecisionWait = defaultTailDecisionWait
+	}
+	if conf.MaxTraces <= 0 {
+		conf.MaxTraces = defaultTailMaxTraces
+	}
+	if conf.MaxSpans <= 0 {
+		conf.MaxSpans = defaultTailMaxSpans
+	}
+	if conf.MeterProvider == nil {
+		conf.MeterProvider = otel.GetMeterProvider()
+	}
+	p := &TailSamplingProcessor{
+		next:    next,
+		conf:    conf,
+		base:    sdktrace.TraceIDRatioBased(min(max(conf.BaseRate, 0), 1)),
+		traces:  make(map[trace.TraceID]*list.Element),
+		order:   list.New(),
+		decided: make(map[trace.TraceID]tailDecision),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	meter := conf.MeterProvider.Meter(tailMeterName)
+	p.traceCounter, _ = meter.Int64Counter(
+		"tail_sampling.traces",
+		metric.WithDescription("Traces decided by the tail sampling processor, partitioned by decision."),
+	)
+	p.dropCounter, _ = meter.Int64Counter(
+		"tail_sampling.spans_dropped",
+		metric.WithDescription("Spans dropped by the tail sampling processor, partitioned by reason."),
+	)
+	p.evictCounter, _ = meter.Int64Counter(
+		"tail_sampling.traces_evicted",
+		metric.WithDescription("Traces decided before the decision wait because the buffer was full."),
+	)
+
+	routine.GoSafe(p.loop)
+	return p
+}
+
+func (p *TailSamplingProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *TailSamplingProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	id := s.SpanContext().TraceID()
+	now := time.Now()
+
+	p.mu.Lock()
+	if d, ok := p.decided[id]; ok {
+		// trace已经决策，后到的span跟随之前的决定
+		p.mu.Unlock()
+		if d.keep {
+			p.next.OnEnd(s)
+		} else {
+			p.recordDrop(tailDropLate, 1)
+		}
+		return
+	}
+
+	elem, ok := p.traces[id]
+	if !ok {
+		elem = p.order.PushBack(&tailTrace{id: id, first: now})
+		p.traces[id] = elem
+	}
+	t := elem.Value.(*tailTrace)
+	t.spans = append(t.spans, s)
+	p.spans++
+	if d := p.spanDecision(s); d != "" && t.decision != TailDecisionError {
+		t.decision = d
+	}
+
+	var ready []*tailTrace
+	// 本进程的根span结束，trace在本进程内已经完整
+	if !s.Parent().IsValid() || s.Parent().IsRemote() {
+		ready = append(ready, p.remove(elem))
+	}
+	evicted := 0
+	for p.order.Len() > 0 && (p.order.Len() > p.conf.MaxTraces || p.spans > p.conf.MaxSpans) {
+		ready = append(ready, p.remove(p.order.Front()))
+		evicted++
+	}
+	p.mu.Unlock()
+
+	if evicted > 0 {
+		p.evictCounter.Add(context.Background(), int64(evicted))
+	}
+	p.export(ready)
+}
+
+func (p *TailSamplingProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+	p.flushAll()
+	return p.next.Shutdown(ctx)
+}
+
+// ForceFlush 对所有缓冲中的trace立即决策并刷新下游processor
+func (p *TailSamplingProcessor) ForceFlush(ctx context.Context) error {
+	p.flushAll()
+	return p.next.ForceFlush(ctx)
+}
+
+func (p *TailSamplingProcessor) loop() {
+	defer close(p.done)
+	interval := max(p.conf.DecisionWait/4, 10*time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.flushExpired(now)
+		}
+	}
+}
+
+func (p *TailSamplingProcessor) flushExpired(now time.Time) {
+	var ready []*tailTrace
+	p.mu.Lock()
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		if now.Sub(e.Value.(*tailTrace).first) < p.conf.DecisionWait {
+			break
+		}
+		ready = append(ready, p.remove(e))
+	}
+	for id, d := range p.decided {
+		if now.After(d.expires) {
+			delete(p.decided, id)
+		}
+	}
+	p.mu.Unlock()
+	p.export(ready)
+}
+
+func (p *TailSamplingProcessor) flushAll() {
+	var ready []*tailTrace
+	p.mu.Lock()
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		ready = append(ready, p.remove(e))
+	}
+	p.mu.Unlock()
+	p.export(ready)
+}
+
+// remove 从缓冲中移除trace并记录决策，调用方需持有锁
+func (p *TailSamplingProcessor) remove(e *list.Element) *tailTrace {
+	t := p.order.Remove(e).(*tailTrace)
+	delete(p.traces, t.id)
+	p.spans -= len(t.spans)
+
+	if t.decision == "" {
+		t.decision = TailDecisionDropped
+		res := p.base.ShouldSample(sdktrace.SamplingParameters{TraceID: t.id})
+		if res.Decision == sdktrace.RecordAndSample {
+			t.decision = TailDecisionBase
+		}
+	}
+	// 记住决策，同一trace后到的span(如异步任务)跟随同样的决定
+	if len(p.decided) < p.conf.MaxTraces {
+		p.decided[t.id] = tailDecision{
+			keep:    t.decision != TailDecisionDropped,
+			expires: time.Now().Add(p.conf.DecisionWait),
+		}
+	}
+	return t
+}
+
+func (p *TailSamplingProcessor) spanDecision(s sdktrace.ReadOnlySpan) string {
+	if s.Status().Code == codes.Error {
+		return TailDecisionError
+	}
+	if p.conf.LatencyThreshold > 0 && s.EndTime().Sub(s.StartTime()) >= p.conf.LatencyThreshold {
+		return TailDecisionLatency
+	}
+	return ""
+}
+
+func (p *TailSamplingProcessor) export(traces []*tailTrace) {
+	for _, t := range traces {
+		p.traceCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("decision", t.decision)))
+		if t.decision == TailDecisionDropped {
+			p.recordDrop(tailDropSampledOut, len(t.spans))
+			continue
+		}
+		for _, s := range t.spans {
+			p.next.OnEnd(s)
+		}
+	}
+}
+
+func (p *TailSamplingProcessor) recordDrop(reason string, n int) {
+	p.dropCounter.Add(context.Background(), int64(n), metric.WithAttributes(attribute.String("reason", reason)))
+}
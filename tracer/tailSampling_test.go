@@ -0,0 +1,157 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tailSamplingTest struct {
+	exporter  *tracetest.InMemoryExporter
+	reader    *sdkmetric.ManualReader
+	processor *TailSamplingProcessor
+	tracer    trace.Tracer
+}
+
+func newTailSamplingTest(t *testing.T, conf TailSamplingConf) *tailSamplingTest {
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	conf.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	processor := NewTailSamplingProcessor(sdktrace.NewSimpleSpanProcessor(exporter), conf)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return &tailSamplingTest{
+		exporter:  exporter,
+		reader:    reader,
+		processor: processor,
+		tracer:    tp.Tracer("test"),
+	}
+}
+
+// startTrace 创建根span和一个子span，根span结束时触发决策
+func (s *tailSamplingTest) startTrace(child func(trace.Span)) trace.TraceID {
+	ctx, root := s.tracer.Start(context.Background(), "root")
+	_, span := s.tracer.Start(ctx, "child")
+	if child != nil {
+		child(span)
+	}
+	span.End()
+	root.End()
+	return root.SpanContext().TraceID()
+}
+
+func (s *tailSamplingTest) exportedTraces() map[trace.TraceID]int {
+	out := make(map[trace.TraceID]int)
+	for _, span := range s.exporter.GetSpans() {
+		out[span.SpanContext.TraceID()]++
+	}
+	return out
+}
+
+func (s *tailSamplingTest) counter(t *testing.T, name, key, value string) int64 {
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, s.reader.Collect(context.Background(), &rm))
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if v, ok := dp.Attributes.Value(attribute.Key(key)); key == "" || ok && v.AsString() == value {
+					total += dp.Value
+				}
+			}
+		}
+	}
+	return total
+}
+
+func TestTailSamplingKeepsErrorAndSlowTraces(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{LatencyThreshold: 20 * time.Millisecond})
+
+	errTrace := s.startTrace(func(span trace.Span) { span.SetStatus(codes.Error, "boom") })
+	slowTrace := s.startTrace(func(trace.Span) { time.Sleep(25 * time.Millisecond) })
+	okTrace := s.startTrace(nil)
+
+	traces := s.exportedTraces()
+	// 整条trace都被导出，包括没有错误的根span
+	assert.Equal(t, 2, traces[errTrace])
+	assert.Equal(t, 2, traces[slowTrace])
+	assert.Zero(t, traces[okTrace])
+
+	assert.EqualValues(t, 1, s.counter(t, "tail_sampling.traces", "decision", TailDecisionError))
+	assert.EqualValues(t, 1, s.counter(t, "tail_sampling.traces", "decision", TailDecisionLatency))
+	assert.EqualValues(t, 1, s.counter(t, "tail_sampling.traces", "decision", TailDecisionDropped))
+	assert.EqualValues(t, 2, s.counter(t, "tail_sampling.spans_dropped", "reason", tailDropSampledOut))
+}
+
+func TestTailSamplingBaseRate(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{BaseRate: 1})
+	id := s.startTrace(nil)
+	assert.Equal(t, 2, s.exportedTraces()[id])
+	assert.EqualValues(t, 1, s.counter(t, "tail_sampling.traces", "decision", TailDecisionBase))
+}
+
+func TestTailSamplingLateSpanFollowsDecision(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{})
+
+	ctx, root := s.tracer.Start(context.Background(), "root")
+	_, late := s.tracer.Start(ctx, "async")
+	root.SetStatus(codes.Error, "boom")
+	root.End()
+	late.End()
+
+	assert.Equal(t, 2, s.exportedTraces()[root.SpanContext().TraceID()])
+}
+
+func TestTailSamplingDecisionWait(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{DecisionWait: 20 * time.Millisecond})
+
+	// 根span在其他进程，本进程只有子span，超时后决策
+	ctx, root := s.tracer.Start(context.Background(), "root")
+	_, span := s.tracer.Start(ctx, "child")
+	span.SetStatus(codes.Error, "boom")
+	span.End()
+	assert.Empty(t, s.exporter.GetSpans())
+
+	assert.Eventually(t, func() bool { return len(s.exporter.GetSpans()) == 1 }, time.Second, 5*time.Millisecond)
+	root.End()
+	assert.Len(t, s.exporter.GetSpans(), 2)
+}
+
+func TestTailSamplingMemoryLimit(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{MaxSpans: 2})
+
+	ctx, root := s.tracer.Start(context.Background(), "root")
+	for i := 0; i < 3; i++ {
+		_, span := s.tracer.Start(ctx, "child")
+		span.SetStatus(codes.Error, "boom")
+		span.End()
+	}
+	// 超过MaxSpans时提前决策，不再等待根span
+	assert.NotEmpty(t, s.exporter.GetSpans())
+	assert.Positive(t, s.counter(t, "tail_sampling.traces_evicted", "", ""))
+	root.End()
+}
+
+func TestTailSamplingForceFlush(t *testing.T) {
+	s := newTailSamplingTest(t, TailSamplingConf{})
+
+	ctx, root := s.tracer.Start(context.Background(), "root")
+	_, span := s.tracer.Start(ctx, "child")
+	span.SetStatus(codes.Error, "boom")
+	span.End()
+	assert.NoError(t, s.processor.ForceFlush(context.Background()))
+	assert.Len(t, s.exporter.GetSpans(), 1)
+	root.End()
+}
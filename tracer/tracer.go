@@ -3,18 +3,22 @@ package tracer
 import (
 	"context"
 	"crypto/tls"
-	"log"
+	"errors"
+	"fmt"
+	"net/url"
 
+	"github.com/RollNA/harbour/zLog"
+	"go.uber.org/zap"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc/credentials"
 
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
@@ -23,80 +27,156 @@ type TransportProtocolType string
 const (
 	TransportProtocolHTTP TransportProtocolType = "HTTP"
 	TransportProtocolGRPC TransportProtocolType = "GRPC"
+	// 格式化的JSON输出到标准输出
+	TransportProtocolSTDOUT TransportProtocolType = "STDOUT"
+	// 每行一个span的JSON，按TraceConf.File滚动
+	TransportProtocolFILE TransportProtocolType = "FILE"
+	// 保存在内存中，通过MemorySpans读取，用于单元测试
+	TransportProtocolMEMORY TransportProtocolType = "MEMORY"
 )
 
 type TraceConf struct {
-	ServiceName       string                `mapstructure:"serviceName"`
-	CollectorURL      string                `mapstructure:"collectorURL"`
-	Insecure          bool                  `mapstructure:"insecure"`
+	// 为空时使用OTEL_SERVICE_NAME
+	ServiceName string `mapstructure:"serviceName"`
+	// 为空时使用OTEL_EXPORTER_OTLP_TRACES_ENDPOINT或OTEL_EXPORTER_OTLP_ENDPOINT，
+	// 其他OTEL_EXPORTER_OTLP_*(如HEADERS、TIMEOUT)由exporter读取
+	CollectorURL string `mapstructure:"collectorURL"`
+	Insecure     bool   `mapstructure:"insecure"`
+	// 为空时按OTEL_EXPORTER_OTLP_PROTOCOL选择，默认GRPC。
+	// STDOUT、FILE、MEMORY不需要collector，用于本地开发和测试
 	TransportProtocol TransportProtocolType `mapstructure:"transportProtocol"`
+	// FILE模式的文件和滚动配置
+	File         TraceFileConf `mapstructure:"file"`
+	ResourceConf `mapstructure:",squash"`
+	// 为空时全部采样，运行时可通过UpdateSampling修改
+	Sampling SamplingConf `mapstructure:"sampling"`
+	// 设置后按trace缓冲span，保留有错误或慢的trace，其余按BaseRate保留
+	TailSampling *TailSamplingConf `mapstructure:"tailSampling"`
+	// 按顺序尝试提取的trace格式，注入时写入所有格式，为空时为tracecontext、baggage。
+	// 可选tracecontext、baggage、b3、b3multi、jaeger、xray
+	Propagators []PropagatorType `mapstructure:"propagators"`
 }
 
+// InitTracer 初始化失败时不退出进程，记录告警并退化为不导出的no-op TracerProvider，
+// 返回的函数在退出前调用以导出剩余的span
 func InitTracer(conf TraceConf) func(context.Context) error {
-	var (
-		serviceName  = conf.ServiceName
-		collectorURL = conf.CollectorURL
-		insecure     = conf.Insecure
-	)
-	defer func() {
-		if r := recover(); r != nil {
-			log.Fatal("[InitTracer] recover failed")
+	shutdown, err := InitTracerE(conf)
+	if err != nil {
+		zLog.Warn("InitTracer failed, tracing disabled", zap.Error(err))
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		// 不导出span时仍然透传上游的trace
+		propagator, perr := NewPropagator(conf.Propagators)
+		if perr != nil {
+			propagator, _ = NewPropagator(nil)
 		}
-	}()
+		otel.SetTextMapPropagator(propagator)
+		return func(context.Context) error { return nil }
+	}
+	return shutdown
+}
 
-	var exporter *otlptrace.Exporter
-	var err error
-	switch conf.TransportProtocol {
+// InitTracerE 初始化全局TracerProvider，返回的shutdown会关闭TracerProvider，
+// batcher中未导出的span会先被导出
+func InitTracerE(conf TraceConf) (shutdown func(context.Context) error, err error) {
+	propagator, err := NewPropagator(conf.Propagators)
+	if err != nil {
+		return nil, fmt.Errorf("create propagator: %w", err)
+	}
+	exporter, err := newTraceExporter(conf)
+	if err != nil {
+		return nil, fmt.Errorf("create trace exporter: %w", err)
+	}
+	if err := UpdateSampling(conf.Sampling); err != nil {
+		_ = exporter.Shutdown(context.Background())
+		return nil, fmt.Errorf("set sampling: %w", err)
+	}
+	resources, err := newResource(conf.ServiceName, conf.ResourceConf)
+	if err != nil {
+		_ = exporter.Shutdown(context.Background())
+		return nil, fmt.Errorf("create resource: %w", err)
+	}
+
+	var processor sdktrace.SpanProcessor
+	if transportProtocol(conf.TransportProtocol, "TRACES") == TransportProtocolMEMORY {
+		// span结束后立即可以读取
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	} else {
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+	if conf.TailSampling != nil {
+		processor = NewTailSamplingProcessor(processor, *conf.TailSampling)
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(globalSampler()),
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resources),
+	}
+	if usesXRay(conf.Propagators) {
+		// X-Ray要求trace id的前8位是时间戳
+		opts = append(opts, sdktrace.WithIDGenerator(xray.NewIDGenerator()))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+func newTraceExporter(conf TraceConf) (sdktrace.SpanExporter, error) {
+	protocol := transportProtocol(conf.TransportProtocol, "TRACES")
+	if isLocalProtocol(protocol) {
+		return newLocalExporter(conf, protocol)
+	}
+	if err := validateCollectorURL(protocol, conf.CollectorURL, "TRACES"); err != nil {
+		return nil, err
+	}
+	// CollectorURL为空时由exporter读取OTEL_EXPORTER_OTLP_*环境变量
+	switch protocol {
 	case TransportProtocolHTTP:
-		secureOption := otlptracehttp.WithTLSClientConfig(&tls.Config{})
-		if insecure {
-			secureOption = otlptracehttp.WithInsecure()
+		var opts []otlptracehttp.Option
+		if conf.CollectorURL != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(conf.CollectorURL))
+		}
+		switch exporterSecurity(conf.CollectorURL, conf.Insecure) {
+		case otlpSecurityInsecure:
+			opts = append(opts, otlptracehttp.WithInsecure())
+		case otlpSecurityTLS:
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(&tls.Config{}))
 		}
-		exporter, err = otlptrace.New(
-			context.Background(),
-			otlptracehttp.NewClient(
-				otlptracehttp.WithEndpointURL(collectorURL),
-				secureOption,
-			),
-		)
+		return otlptrace.New(context.Background(), otlptracehttp.NewClient(opts...))
 	default:
-		secureOption := otlptracegrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, ""))
-		if insecure {
-			secureOption = otlptracegrpc.WithInsecure()
+		var opts []otlptracegrpc.Option
+		if conf.CollectorURL != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(conf.CollectorURL))
 		}
-		exporter, err = otlptrace.New(
-			context.Background(),
-			otlptracegrpc.NewClient(
-				secureOption,
-				otlptracegrpc.WithEndpoint(collectorURL),
-			),
-		)
+		switch exporterSecurity(conf.CollectorURL, conf.Insecure) {
+		case otlpSecurityInsecure:
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		case otlpSecurityTLS:
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		}
+		return otlptrace.New(context.Background(), otlptracegrpc.NewClient(opts...))
+	}
+}
 
+// validateCollectorURL otlp exporter对不合法的地址只打印日志，这里提前返回错误
+func validateCollectorURL(protocol TransportProtocolType, collectorURL, signal string) error {
+	if collectorURL == "" {
+		if otlpEnv(signal, "ENDPOINT") == "" {
+			return errors.New("collector url is empty, set CollectorURL or OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		return nil
 	}
+	if protocol != TransportProtocolHTTP {
+		return nil
+	}
+	u, err := url.Parse(collectorURL)
 	if err != nil {
-		log.Fatal(err)
-	}
-	resources, err := resource.New(
-		context.Background(),
-		resource.WithAttributes(
-			attribute.String("service.name", serviceName),
-			attribute.String("library.language", "go"),
-		),
-	)
-	if err != nil {
-		log.Fatal("Could not set resources: ", err)
+		return err
 	}
-
-	otel.SetTracerProvider(
-		sdktrace.NewTracerProvider(
-			sdktrace.WithSampler(sdktrace.AlwaysSample()),
-			sdktrace.WithBatcher(exporter),
-			sdktrace.WithResource(resources),
-		),
-	)
-
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-	return exporter.Shutdown
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("collector url %q must include scheme and host", collectorURL)
+	}
+	return nil
 }
 
 func TraceIdFromContext(ctx context.Context) string {
@@ -107,16 +187,13 @@ func TraceIdFromContext(ctx context.Context) string {
 	return ""
 }
 
+// InitContextWithTrace 以远程span为父span，ID不合法时返回原ctx，之后开始的span会是新的trace
+//
+// Deprecated: use ContextWithRemoteParent, which returns an error for malformed IDs
 func InitContextWithTrace(ctx context.Context, t32, s16 string) context.Context {
-	tid, err := trace.TraceIDFromHex(t32)
-	if err != nil {
-		tid, _ = trace.TraceIDFromHex("01000000000000000000000000000000")
-	}
-	sid, err := trace.SpanIDFromHex(s16)
+	remote, err := ContextWithRemoteParent(ctx, t32, s16, true)
 	if err != nil {
-		sid, _ = trace.SpanIDFromHex("0200000000000000")
+		return ctx
 	}
-
-	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid})
-	return trace.ContextWithSpanContext(ctx, sc)
+	return remote
 }
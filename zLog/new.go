@@ -84,7 +84,7 @@ func GetDefaultLogger() *zap.Logger {
 // Deprecated: use TraceXXX eg TraceDebug.
 func WithContext(ctx context.Context) *zap.Logger {
 	// notice logger will be clone much times by this func
-	return defaultLogger.With(TraceIdFromCtx(ctx))
+	return defaultLogger.With(TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))
 }
 
 func With(fields ...zap.Field) *zap.Logger {
@@ -7,8 +7,9 @@ import (
 )
 
 const (
-	bizKey     = "biz"
-	traceIdKey = "traceId"
+	bizKey       = "biz"
+	traceIdKey   = "traceId"
+	requestIdKey = "requestId"
 )
 
 type KVPair struct {
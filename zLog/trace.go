@@ -36,30 +36,57 @@ func TraceId(traceId string) zap.Field {
 	return zap.String("traceId", traceId)
 }
 
+type requestIdCtxKey struct{}
+
+// ContextWithRequestId 把请求ID放入ctx，Trace*日志会自动带上
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey{}, requestId)
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdCtxKey{}).(string)
+	return requestId
+}
+
+func RequestIdFromCtx(ctx context.Context) zap.Field {
+	requestId := RequestIdFromContext(ctx)
+	if requestId == "" {
+		return zap.Skip()
+	}
+	return RequestId(requestId)
+}
+
+func RequestId(requestId string) zap.Field {
+	return zap.String(requestIdKey, requestId)
+}
+
 func TraceDebug(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Debug(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Debug(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TraceInfo(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Info(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Info(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TraceWarn(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Warn(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Warn(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TraceError(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Error(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Error(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TraceDPanic(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.DPanic(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.DPanic(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TracePanic(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Panic(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Panic(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }
 
 func TraceFatal(ctx context.Context, msg string, fields ...zap.Field) {
-	defaultLogger.Fatal(msg, append(fields, TraceIdFromCtx(ctx))...)
+	defaultLogger.Fatal(msg, append(fields, TraceIdFromCtx(ctx), RequestIdFromCtx(ctx))...)
 }